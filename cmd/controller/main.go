@@ -1,11 +1,14 @@
 package main
 
 import (
+	"crypto/subtle"
 	goflag "flag"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -14,7 +17,12 @@ import (
 	"github.com/golang/glog"
 	"github.com/kube-node/kube-machine/pkg/controller"
 	"github.com/kube-node/kube-machine/pkg/controller/node"
+	nodecommandcontroller "github.com/kube-node/kube-machine/pkg/controller/nodecommand"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
 	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
+	"github.com/kube-node/kube-machine/pkg/shard"
+	"github.com/kube-node/kube-machine/pkg/webhook"
 	nodesetclient "github.com/kube-node/nodeset/pkg/client/clientset/versioned"
 	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
 	flag "github.com/spf13/pflag"
@@ -22,12 +30,17 @@ import (
 	"k8s.io/api/core/v1"
 	extapiclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -36,9 +49,34 @@ var master *string = flag.String("master", "", "The address of the Kubernetes AP
 var healthListenAddress *string = flag.String("health-listen-address", ":8081", "The listen address for health checking")
 var maxMigrationWaitSeconds *int = flag.Int("max-migration-wait-seconds", 20, "Maximum time to wait for a migration until a deleted node gets deleted at cloud-provider. A migration happens if the actual kubelet registers with a different name than specified in the node resource OR when the kubelet deletes the existing node and recreates it(happens on every cloud-provider)")
 var promAddr *string = flag.String("prometheus", ":8082", "The address for Prometheus")
+var webhookListenAddress *string = flag.String("webhook-listen-address", "", "The listen address for the validating admission webhook. The webhook is disabled if empty")
+var webhookTLSCertFile *string = flag.String("webhook-tls-cert-file", "", "Path to the TLS certificate of the admission webhook")
+var webhookTLSKeyFile *string = flag.String("webhook-tls-key-file", "", "Path to the TLS key of the admission webhook")
+var webhookControllerUsers *[]string = flag.StringSlice("webhook-controller-users", []string{"system:serviceaccount:kube-system:kube-machine"}, "Users which are allowed to change the node annotations owned by kube-machine")
+var stateNamespace *string = flag.String("state-namespace", "kube-system", "The namespace in which kube-machine stores its state, like the nodeclass snapshots of the nodes")
+var resourceNamespaces *[]string = flag.StringSlice("resource-namespaces", []string{"kube-system"}, "Namespaces of the Secrets & ConfigMaps which node class resources may reference")
+var manageCRDs *bool = flag.Bool("manage-crds", true, "Create and update the custom resource definitions on startup. Disable it if the custom resource definitions are managed externally")
+var controllerName *string = flag.String("controller-name", "kube-machine", "The name of this controller. Only nodes whose nodeclass specifies this name as nodeController are managed")
+var nodeLabelSelector *string = flag.String("node-label-selector", "", "Only watch nodes matching this label selector")
+var nodeClassLabelSelector *string = flag.String("nodeclass-label-selector", "", "Only watch nodeclasses matching this label selector")
+var nodeCommandLabelSelector *string = flag.String("nodecommand-label-selector", "", "Only watch nodecommands matching this label selector")
+var nodeCommandParallelism *int = flag.Int("nodecommand-parallelism", 10, "Maximum number of nodes a NodeCommand gets executed on at once")
+var kubeletLeaseHeartbeat *bool = flag.Bool("kubelet-lease-heartbeat", false, "Keep not yet joined nodes alive by renewing their kubelet Lease in kube-node-lease instead of setting a temporary ready condition")
+var joinStabilityPeriod *time.Duration = flag.Duration("join-stability-period", 0, "Time the machine & boot id reported by a joined kubelet must stay unchanged before the node is considered as running. Disabled if 0")
+var defaultPhaseTimeout *time.Duration = flag.Duration("default-phase-timeout", time.Hour, "Timeout of the pending, provisioning & launching phase if the nodeclass of a node specifies none or is missing. Disabled if 0")
+var shutdownTimeout *time.Duration = flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for pending migrations & deletions on shutdown. Unfinished ones get resumed on the next start")
+var workerStallTimeout *time.Duration = flag.Duration("worker-stall-timeout", 30*time.Minute, "Liveness fails if none of the node workers made progress within this time while nodes are queued. Disabled if 0")
+var debugAPI *bool = flag.Bool("debug-api", false, "Serve a read-only JSON API with the state of the controller under /debug/ on the health listen address")
+var debugTokenFile *string = flag.String("debug-token-file", "", "Path to a file with the bearer token required for the debug API. No authentication if empty")
+var sharding *bool = flag.Bool("sharding", false, "Distribute the nodes across all running replicas by the hash of the node name. Membership is tracked via Leases in the state namespace")
+var shardIdentity *string = flag.String("shard-identity", "", "The identity of this replica within the shards. Defaults to the hostname")
+var shardLeaseDuration *time.Duration = flag.Duration("shard-lease-duration", 15*time.Second, "Duration after which a replica which did not renew its Lease is removed from the shards")
 
 const (
-	workerCount = 25
+	workerCount            = 25
+	nodeCommandWorkerCount = 5
+
+	crdCheckInterval = 30 * time.Second
 )
 
 func main() {
@@ -48,6 +86,7 @@ func main() {
 
 	metrics := node.NewControllerMetrics()
 	go metrics.Serve(*promAddr)
+	libmachine.SetDriverCallObserver(metrics.ObserveDriverCall)
 
 	var config *rest.Config
 	var err error
@@ -58,24 +97,38 @@ func main() {
 		panic(err.Error())
 	}
 
+	for _, selector := range []string{*nodeLabelSelector, *nodeClassLabelSelector, *nodeCommandLabelSelector} {
+		if _, err := labels.Parse(selector); err != nil {
+			panic(err)
+		}
+	}
+
 	kubeClient := kubernetes.NewForConfigOrDie(config)
 	apiextensionsclientset := extapiclient.NewForConfigOrDie(config)
 
-	err = nodeclass.EnsureCustomResourceDefinitions(apiextensionsclientset)
-	if err != nil {
-		panic(err)
+	if *manageCRDs {
+		err = nodeclass.EnsureCustomResourceDefinitions(apiextensionsclientset)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	nodesetClient := nodesetclient.NewForConfigOrDie(config)
+	nodeCommandClient, err := nodecommand.NewClient(config)
+	if err != nil {
+		panic(err)
+	}
 
 	nodeQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
 	nodeIndexer, nodeInformer := cache.NewIndexerInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = *nodeLabelSelector
 				return kubeClient.CoreV1().Nodes().List(options)
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = *nodeLabelSelector
 				return kubeClient.CoreV1().Nodes().Watch(options)
 			},
 		},
@@ -103,35 +156,98 @@ func main() {
 				}
 			},
 		},
-		cache.Indexers{},
+		cache.Indexers{node.NodeClassIndex: node.NodeClassIndexFunc},
 	)
 
-	nodeClassStore, nodeClassController := cache.NewInformer(
+	nodeClassInformer := cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = *nodeClassLabelSelector
 				return nodesetClient.NodesetV1alpha1().NodeClasses().List(options)
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = *nodeClassLabelSelector
 				return nodesetClient.NodesetV1alpha1().NodeClasses().Watch(options)
 			},
 		},
 		&v1alpha1.NodeClass{},
 		5*time.Minute,
-		cache.ResourceEventHandlerFuncs{},
+		cache.Indexers{},
+	)
+
+	nodeCommandQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	nodeCommandStore, nodeCommandController := cache.NewInformer(
+		cache.NewFilteredListWatchFromClient(nodeCommandClient, nodecommand.NodeCommandResourcePlural, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.Everything().String()
+			options.LabelSelector = *nodeCommandLabelSelector
+		}),
+		&nodecommand.NodeCommand{},
+		5*time.Minute,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				key, err := cache.MetaNamespaceKeyFunc(obj)
+				if err == nil {
+					nodeCommandQueue.Add(key)
+				}
+			},
+			UpdateFunc: func(old interface{}, new interface{}) {
+				key, err := cache.MetaNamespaceKeyFunc(new)
+				if err == nil {
+					nodeCommandQueue.Add(key)
+				}
+			},
+		},
 	)
 
+	var membership *shard.Membership
+	if *sharding {
+		identity := *shardIdentity
+		if identity == "" {
+			identity, err = os.Hostname()
+			if err != nil {
+				panic(err)
+			}
+		}
+		membership = shard.New(kubeClient, *stateNamespace, *controllerName, identity, *shardLeaseDuration)
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: *controllerName})
+
+	// Parents & fragments do not need to match the nodeclass label selector
+	var getUncachedNodeClass node.NodeClassGetter
+	if *nodeClassLabelSelector != "" {
+		getUncachedNodeClass = func(name string) (*v1alpha1.NodeClass, error) {
+			return nodesetClient.NodesetV1alpha1().NodeClasses().Get(name, metav1.GetOptions{})
+		}
+	}
+
 	//Is default on docker-machine. Lets stick to defaults.
 	ssh.SetDefaultClient(ssh.External)
 
 	c := node.New(
 		kubeClient,
+		recorder,
+		*controllerName,
 		nodeQueue,
 		nodeIndexer,
 		nodeInformer,
-		nodeClassStore,
-		nodeClassController,
+		nodeClassInformer,
+		*nodeLabelSelector,
+		getUncachedNodeClass,
 		time.Duration(*maxMigrationWaitSeconds)*time.Second,
-		metrics)
+		metrics,
+		*stateNamespace,
+		*resourceNamespaces,
+		membership,
+		*kubeletLeaseHeartbeat,
+		*joinStabilityPeriod,
+		*shutdownTimeout,
+		*workerStallTimeout,
+		*defaultPhaseTimeout)
 
 	stop := make(chan struct{})
 	osc := make(chan os.Signal, 2)
@@ -141,12 +257,37 @@ func main() {
 		close(stop)
 	}()
 
-	go startHealth(c)
+	cc := nodecommandcontroller.New(
+		nodeCommandClient,
+		nodeCommandQueue,
+		nodeCommandStore,
+		nodeCommandController,
+		nodeIndexer,
+		nodeInformer,
+		c.(controller.NodeFilter),
+		c.(controller.HostLoader),
+		*nodeCommandParallelism)
+
+	if *webhookListenAddress != "" {
+		go func() {
+			wh := webhook.New(*controllerName, *webhookControllerUsers, *resourceNamespaces)
+			log.Fatal(wh.ListenAndServeTLS(*webhookListenAddress, *webhookTLSCertFile, *webhookTLSKeyFile))
+		}()
+	}
+
+	var crdChecker *nodeclass.CustomResourceDefinitionChecker
+	// Externally managed custom resource definitions are not checked, kube-machine might not be allowed to read them
+	if *manageCRDs {
+		crdChecker = nodeclass.NewCustomResourceDefinitionChecker(apiextensionsclientset, crdCheckInterval)
+	}
+	go startHealth(c, crdChecker)
+	go cc.Run(nodeCommandWorkerCount, stop)
 	c.Run(workerCount, stop)
 }
 
-func startHealth(c controller.Interface) {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+func startHealth(c controller.Interface, crdChecker *nodeclass.CustomResourceDefinitionChecker) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		if c.IsReady() {
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte("OK"))
@@ -155,5 +296,56 @@ func startHealth(c controller.Interface) {
 			w.Write([]byte("Not ready"))
 		}
 	})
-	log.Fatal(http.ListenAndServe(*healthListenAddress, nil))
+
+	if hc, ok := c.(controller.HealthChecker); ok {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			writeHealth(w, hc.Live())
+		})
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			err := hc.Ready()
+			if err == nil && crdChecker != nil {
+				err = crdChecker.Check()
+			}
+			writeHealth(w, err)
+		})
+	}
+
+	if d, ok := c.(controller.Debuggable); ok && *debugAPI {
+		token := ""
+		if *debugTokenFile != "" {
+			data, err := ioutil.ReadFile(*debugTokenFile)
+			if err != nil {
+				panic(err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		mux.Handle("/debug/", bearerTokenAuth(token, d.DebugHandler()))
+	}
+
+	log.Fatal(http.ListenAndServe(*healthListenAddress, mux))
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		glog.V(4).Infof("Health check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// bearerTokenAuth only passes requests with the given bearer token. All requests pass if the token is empty.
+func bearerTokenAuth(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,10 @@
+package controller
+
+import (
+	"net/http"
+)
+
+// Debuggable is implemented by controllers which expose their state via a read-only debug API
+type Debuggable interface {
+	DebugHandler() http.Handler
+}
@@ -0,0 +1,9 @@
+package controller
+
+// HealthChecker is implemented by controllers which distinguish between liveness and readiness
+type HealthChecker interface {
+	// Live returns an error if the controller is stuck and should be restarted
+	Live() error
+	// Ready returns an error if the controller should not be considered as serving, i.e. it does not process its resources
+	Ready() error
+}
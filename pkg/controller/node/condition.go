@@ -4,53 +4,196 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	nodehelper "github.com/kube-node/kube-machine/pkg/node"
 
+	coordinationv1 "k8s.io/api/coordination/v1"
 	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 const (
-	tempReadyConditionReason = "Kubelet is being provisioned by the nodecontroller"
+	// tempReadyConditionHeartbeatPeriod must be shorter than the node monitor grace period of the controller-manager
+	tempReadyConditionHeartbeatPeriod = 20 * time.Second
+
+	kubeletLeaseNamespace = "kube-node-lease"
+	kubeletLeaseDuration  = 40
+
+	heartbeatActionCondition = "condition"
+	heartbeatActionLease     = "lease"
+	heartbeatActionRemoved   = "removed"
 )
 
+// readyConditionWorker keeps the nodes of this controller alive until their kubelet joined.
+// Without a heartbeat the controller-manager would consider them as unhealthy and eventually delete them.
+// The heartbeat is either a temporary ready condition or a renewal of the kubelet Lease of the node.
 func (c *Controller) readyConditionWorker() {
 	nlist := c.nodeIndexer.List()
 	for _, obj := range nlist {
 		node := obj.(*v1.Node)
+		if !c.ownsNode(node.Name) {
+			continue
+		}
 
-		con := v1.NodeCondition{
-			Type:               v1.NodeReady,
-			Status:             v1.ConditionTrue,
-			Reason:             tempReadyConditionReason,
-			Message:            "kubelet is not created by kube-machine. This condition prevents node deletion by the controller-manager",
-			LastHeartbeatTime:  metav1.NewTime(time.Now()),
-			LastTransitionTime: metav1.NewTime(time.Now()),
-		}
-
-		var found, updated bool
-		for i := range node.Status.Conditions {
-			if node.Status.Conditions[i].Type == v1.NodeReady {
-				found = true
-				if node.Status.Conditions[i].Reason == tempReadyConditionReason {
-					node.Status.Conditions[i] = con
-					updated = true
-				}
-				break
-			}
+		isControllerNode, err := c.isControllerNode(node)
+		if err != nil {
+			glog.V(0).Infof("failed to identify if node %s belongs to this controller: %v", node.Name, err)
+			continue
+		}
+		// The conditions of nodes of other controllers are none of our business
+		if !isControllerNode {
+			continue
 		}
 
-		if !found {
-			node.Status.Conditions = append(node.Status.Conditions, con)
-			updated = true
+		// Objects from the cache must not be modified
+		node = node.DeepCopy()
+
+		if !needsHeartbeat(node) {
+			c.removeTempReadyCondition(node)
+			continue
 		}
 
-		if updated {
-			glog.V(6).Infof("Updating node ready condition for %s to avoid node deletion by kube-controller-manager", node.Name)
-			_, err := c.client.CoreV1().Nodes().UpdateStatus(node)
-			if err != nil {
+		if c.kubeletLeaseHeartbeat {
+			c.renewKubeletLease(node)
+		} else {
+			c.updateTempReadyCondition(node)
+		}
+	}
+}
 
-				glog.V(0).Infof("Failed to update node ready condition for %s: %v", node.Name, err)
+// needsHeartbeat returns true if the node is being created and its kubelet did not report yet
+func needsHeartbeat(node *v1.Node) bool {
+	if node.DeletionTimestamp != nil || node.Status.NodeInfo.KubeletVersion != "" {
+		return false
+	}
+	switch node.Annotations[phaseAnnotationKey] {
+	case "", phasePending, phaseProvisioning, phaseLaunching:
+		return true
+	}
+	return false
+}
+
+func (c *Controller) updateTempReadyCondition(node *v1.Node) {
+	con := v1.NodeCondition{
+		Type:               v1.NodeReady,
+		Status:             v1.ConditionTrue,
+		Reason:             nodehelper.TempReadyConditionReason,
+		Message:            "kubelet is not created by kube-machine. This condition prevents node deletion by the controller-manager",
+		LastHeartbeatTime:  metav1.NewTime(time.Now()),
+		LastTransitionTime: metav1.NewTime(time.Now()),
+	}
+
+	var found, updated bool
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == v1.NodeReady {
+			found = true
+			existing := node.Status.Conditions[i]
+			if existing.Reason == nodehelper.TempReadyConditionReason && time.Since(existing.LastHeartbeatTime.Time) >= tempReadyConditionHeartbeatPeriod {
+				con.LastTransitionTime = existing.LastTransitionTime
+				node.Status.Conditions[i] = con
+				updated = true
 			}
+			break
 		}
 	}
+
+	if !found {
+		node.Status.Conditions = append(node.Status.Conditions, con)
+		updated = true
+	}
+
+	if updated {
+		glog.V(6).Infof("Updating node ready condition for %s to avoid node deletion by kube-controller-manager", node.Name)
+		c.updateNodeStatus(node, heartbeatActionCondition)
+	}
+}
+
+// removeTempReadyCondition removes the temporary ready condition, in case the kubelet did not replace it
+func (c *Controller) removeTempReadyCondition(node *v1.Node) {
+	conditions := []v1.NodeCondition{}
+	for _, con := range node.Status.Conditions {
+		if con.Type == v1.NodeReady && con.Reason == nodehelper.TempReadyConditionReason {
+			continue
+		}
+		conditions = append(conditions, con)
+	}
+	if len(conditions) == len(node.Status.Conditions) {
+		return
+	}
+
+	glog.V(6).Infof("Removing temporary node ready condition of %s", node.Name)
+	node.Status.Conditions = conditions
+	c.updateNodeStatus(node, heartbeatActionRemoved)
+}
+
+func (c *Controller) updateNodeStatus(node *v1.Node, action string) {
+	_, err := c.client.CoreV1().Nodes().UpdateStatus(node)
+	if kerrors.IsConflict(err) {
+		// The cache is outdated, the next run will use the current node
+		glog.V(6).Infof("Conflict while updating node ready condition for %s: %v", node.Name, err)
+		return
+	}
+	if err != nil {
+		glog.V(0).Infof("Failed to update node ready condition for %s: %v", node.Name, err)
+		return
+	}
+	c.metrics.Heartbeats.WithLabelValues(action).Inc()
+}
+
+// renewKubeletLease renews the Lease which the kubelet uses for its heartbeats.
+// Once the kubelet took over the Lease, it is left untouched.
+func (c *Controller) renewKubeletLease(node *v1.Node) {
+	now := metav1.NewMicroTime(time.Now())
+	leases := c.client.CoordinationV1().Leases(kubeletLeaseNamespace)
+
+	lease, err := c.getKubeletLease(node)
+	if err == nil && lease == nil {
+		_, err = leases.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            node.Name,
+				Namespace:       kubeletLeaseNamespace,
+				OwnerReferences: []metav1.OwnerReference{nodeOwnerReference(node)},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &c.controllerName,
+				LeaseDurationSeconds: int32Ptr(kubeletLeaseDuration),
+				RenewTime:            &now,
+			},
+		})
+	} else if err == nil {
+		if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != c.controllerName {
+			return
+		}
+		if lease.Spec.RenewTime != nil && time.Since(lease.Spec.RenewTime.Time) < tempReadyConditionHeartbeatPeriod {
+			return
+		}
+		// Objects from the cache must not be modified
+		lease = lease.DeepCopy()
+		lease.Spec.RenewTime = &now
+		_, err = leases.Update(lease)
+	}
+
+	if err != nil {
+		glog.V(0).Infof("Failed to renew kubelet lease of node %s: %v", node.Name, err)
+		return
+	}
+	glog.V(6).Infof("Renewed kubelet lease of node %s to avoid node deletion by kube-controller-manager", node.Name)
+	c.metrics.Heartbeats.WithLabelValues(heartbeatActionLease).Inc()
+}
+
+// newKubeletLeaseInformer caches the Leases the kubelets use for their heartbeats
+func newKubeletLeaseInformer(client kubernetes.Interface) (cache.Store, cache.Controller) {
+	return cache.NewInformer(
+		cache.NewListWatchFromClient(client.CoordinationV1().RESTClient(), "leases", kubeletLeaseNamespace, fields.Everything()),
+		&coordinationv1.Lease{},
+		0,
+		cache.ResourceEventHandlerFuncs{},
+	)
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
 }
@@ -5,15 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/kube-node/kube-machine/pkg/controller"
 	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/kube-machine/pkg/shard"
 	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
 
 	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -21,8 +25,8 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	kerrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
 type Controller struct {
@@ -31,21 +35,88 @@ type Controller struct {
 	nodeQueue            workqueue.RateLimitingInterface
 	nodeClassStore       cache.Store
 	nodeClassInformer    cache.Controller
-	client               *kubernetes.Clientset
+	nodeLabelSelector    string
+	getUncachedNodeClass NodeClassGetter
+	client               kubernetes.Interface
+	recorder             record.EventRecorder
+	controllerName       string
 	nodeCreateLock       *sync.Mutex
 	maxMigrationWaitTime time.Duration
 	metrics              *ControllerMetrics
+
+	instanceDetailsLock      *sync.Mutex
+	instanceDetailsRefreshed map[string]time.Time
+
+	stateNamespace     string
+	resourceNamespaces []string
+	snapshotLock       *sync.Mutex
+	snapshots          map[string]string
+	snapshotFailures   map[string]time.Time
+
+	persistLock *sync.Mutex
+	sideEffects map[string]bool
+	unpersisted map[string][]byte
+
+	shard *shard.Membership
+
+	kubeletLeaseHeartbeat bool
+	leaseStore            cache.Store
+	leaseInformer         cache.Controller
+	joinStabilityPeriod   time.Duration
+	defaultPhaseTimeout   time.Duration
+
+	tombstoneQueue       workqueue.RateLimitingInterface
+	tombstoneStore       cache.Store
+	tombstoneInformer    cache.Controller
+	tombstoneLock        *sync.Mutex
+	tombstonesInProgress map[string]bool
+	tombstonesFailing    map[string]string
+	tombstoneWG          *sync.WaitGroup
+	shutdownTimeout      time.Duration
+
+	retryLock *sync.Mutex
+	retries   map[string]debugRetry
+
+	heartbeatLock      *sync.Mutex
+	heartbeats         []workerHeartbeat
+	workerStallTimeout time.Duration
 }
 
 const (
-	phaseAnnotationKey      = "node.k8s.io/state"
-	driverDataAnnotationKey = "node.k8s.io/driver-data"
-	publicIPAnnotationKey   = "node.k8s.io/public-ip"
-	hostnameAnnotationKey   = "node.k8s.io/hostname"
+	phaseAnnotationKey         = "node.k8s.io/state"
+	driverDataAnnotationKey    = "node.k8s.io/driver-data"
+	publicIPAnnotationKey      = "node.k8s.io/public-ip"
+	hostnameAnnotationKey      = "node.k8s.io/hostname"
+	failureReasonAnnotationKey = "node.k8s.io/failure-reason"
+
+	nodeClassOverridesAnnotationKey        = "node.k8s.io/node-class-overrides"
+	nodeClassAppliedOverridesAnnotationKey = "node.k8s.io/node-class-applied-overrides"
+
+	reprovisionAnnotationKey       = "node.k8s.io/reprovision"
+	reprovisionedAnnotationKey     = "node.k8s.io/reprovisioned"
+	reprovisionResultAnnotationKey = "node.k8s.io/reprovision-result"
+	reprovisioningAnnotationKey    = "node.k8s.io/reprovisioning"
+	reprovisionCordonAnnotationKey = "node.k8s.io/reprovision-cordoned"
+
+	powerStateAnnotationKey       = "node.k8s.io/power-state"
+	powerStateActualAnnotationKey = "node.k8s.io/power-state-actual"
+	powerCordonAnnotationKey      = "node.k8s.io/power-cordoned"
+
+	hibernatedAnnotationKey           = "node.k8s.io/hibernated"
+	hibernationProtectedAnnotationKey = "node.k8s.io/hibernation-protected"
 
-	deleteFinalizerName     = "node.k8s.io/delete"
+	nodeClassHashAnnotationKey     = "node.k8s.io/node-class-hash"
+	nodeClassSnapshotAnnotationKey = "node.k8s.io/node-class-snapshot"
+	outdatedAnnotationKey          = "node.k8s.io/outdated"
 
-	controllerName     = "kube-machine"
+	joinedAnnotationKey       = "node.k8s.io/joined"
+	joinedBootIDAnnotationKey = "node.k8s.io/joined-boot-id"
+	systemUUIDAnnotationKey   = "node.k8s.io/system-uuid"
+	phaseSinceAnnotationKey   = "node.k8s.io/phase-since"
+
+	creationClaimAnnotationKey = "node.k8s.io/creation-claim"
+
+	deleteFinalizerName = "node.k8s.io/delete"
 
 	phasePending      = "pending"
 	phaseProvisioning = "provisioning"
@@ -53,48 +124,172 @@ const (
 	phaseRunning      = "running"
 	phaseDeleting     = "deleting"
 
-	conditionUpdatePeriod = 5 * time.Second
-	migrationWorkerPeriod = 5 * time.Second
+	conditionUpdatePeriod   = 5 * time.Second
+	migrationWorkerPeriod   = 5 * time.Second
+	hibernationWorkerPeriod = time.Minute
+	stuckNodeWorkerPeriod   = 30 * time.Second
 )
 
+// OwnedAnnotationKeys are the annotations which must only be written by kube-machine itself
+var OwnedAnnotationKeys = []string{
+	phaseAnnotationKey,
+	driverDataAnnotationKey,
+	publicIPAnnotationKey,
+	hostnameAnnotationKey,
+	failureReasonAnnotationKey,
+	nodeClassAppliedOverridesAnnotationKey,
+	reprovisionedAnnotationKey,
+	reprovisionResultAnnotationKey,
+	reprovisioningAnnotationKey,
+	reprovisionCordonAnnotationKey,
+	powerStateActualAnnotationKey,
+	powerCordonAnnotationKey,
+	hibernatedAnnotationKey,
+	nodeClassHashAnnotationKey,
+	nodeClassSnapshotAnnotationKey,
+	outdatedAnnotationKey,
+	joinedAnnotationKey,
+	joinedBootIDAnnotationKey,
+	systemUUIDAnnotationKey,
+	phaseSinceAnnotationKey,
+	creationClaimAnnotationKey,
+}
+
 var nodeClassNotFoundErr = errors.New("node class not found")
 var nodeNotFoundErr = errors.New("node not found")
 var noNodeClassDefinedErr = errors.New("no node class defined")
 
+// referencedNodeClassNotFoundErr is returned if a parent or fragment of a nodeclass does not exist
+type referencedNodeClassNotFoundErr struct {
+	name     string
+	referrer string
+}
+
+func (e *referencedNodeClassNotFoundErr) Error() string {
+	return fmt.Sprintf("nodeclass %s referenced by %s: %v", e.name, e.referrer, nodeClassNotFoundErr)
+}
+
+// isNodeClassNotFound returns true if the nodeclass itself or one of its parents or fragments does not exist
+func isNodeClassNotFound(err error) bool {
+	if _, ok := err.(*referencedNodeClassNotFoundErr); ok {
+		return true
+	}
+	return err == nodeClassNotFoundErr
+}
+
+// NodeClassGetter returns the nodeclass with the given name from the API server
+type NodeClassGetter func(name string) (*v1alpha1.NodeClass, error)
+
 func New(
-	client *kubernetes.Clientset,
+	client kubernetes.Interface,
+	recorder record.EventRecorder,
+	controllerName string,
 	queue workqueue.RateLimitingInterface,
 	nodeIndexer cache.Indexer,
 	nodeInformer cache.Controller,
-	nodeClassStore cache.Store,
-	nodeClassController cache.Controller,
+	nodeClassInformer cache.SharedIndexInformer,
+	nodeLabelSelector string,
+	getUncachedNodeClass NodeClassGetter,
 	maxMigrationWaitTime time.Duration,
 	metrics *ControllerMetrics,
+	stateNamespace string,
+	resourceNamespaces []string,
+	membership *shard.Membership,
+	kubeletLeaseHeartbeat bool,
+	joinStabilityPeriod time.Duration,
+	shutdownTimeout time.Duration,
+	workerStallTimeout time.Duration,
+	defaultPhaseTimeout time.Duration,
 ) controller.Interface {
-	return &Controller{
+	c := &Controller{
 		nodeInformer:         nodeInformer,
 		nodeIndexer:          nodeIndexer,
 		nodeQueue:            queue,
-		nodeClassInformer:    nodeClassController,
-		nodeClassStore:       nodeClassStore,
+		nodeClassInformer:    nodeClassInformer,
+		nodeClassStore:       nodeClassInformer.GetStore(),
+		nodeLabelSelector:    nodeLabelSelector,
+		getUncachedNodeClass: getUncachedNodeClass,
 		client:               client,
+		recorder:             recorder,
+		controllerName:       controllerName,
 		nodeCreateLock:       &sync.Mutex{},
 		maxMigrationWaitTime: maxMigrationWaitTime,
 		metrics:              metrics,
+
+		instanceDetailsLock:      &sync.Mutex{},
+		instanceDetailsRefreshed: map[string]time.Time{},
+
+		stateNamespace:     stateNamespace,
+		resourceNamespaces: resourceNamespaces,
+		snapshotLock:       &sync.Mutex{},
+		snapshots:          map[string]string{},
+		snapshotFailures:   map[string]time.Time{},
+
+		persistLock: &sync.Mutex{},
+		sideEffects: map[string]bool{},
+		unpersisted: map[string][]byte{},
+
+		shard: membership,
+
+		kubeletLeaseHeartbeat: kubeletLeaseHeartbeat,
+		joinStabilityPeriod:   joinStabilityPeriod,
+		defaultPhaseTimeout:   defaultPhaseTimeout,
+
+		tombstoneQueue:       workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Second, 5*time.Minute)),
+		tombstoneLock:        &sync.Mutex{},
+		tombstonesInProgress: map[string]bool{},
+		tombstonesFailing:    map[string]string{},
+		tombstoneWG:          &sync.WaitGroup{},
+		shutdownTimeout:      shutdownTimeout,
+
+		retryLock: &sync.Mutex{},
+		retries:   map[string]debugRetry{},
+
+		heartbeatLock:      &sync.Mutex{},
+		workerStallTimeout: workerStallTimeout,
 	}
+
+	c.tombstoneStore, c.tombstoneInformer = newTombstoneInformer(client, stateNamespace, controllerName, c.tombstoneQueue)
+	if kubeletLeaseHeartbeat {
+		c.leaseStore, c.leaseInformer = newKubeletLeaseInformer(client)
+	}
+
+	if membership != nil {
+		membership.AddChangeHandler(c.enqueueAllNodes)
+		membership.AddChangeHandler(c.enqueueAllTombstones)
+	}
+
+	nodeClassInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueNodesOfNodeClass(obj)
+		},
+		UpdateFunc: func(old interface{}, new interface{}) {
+			c.enqueueNodesOfNodeClass(new)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			c.enqueueNodesOfNodeClass(obj)
+		},
+	})
+
+	return c
 }
 
-func (c *Controller) processNextItem() bool {
+func (c *Controller) processNextItem(worker int) bool {
 	// Wait until there is a new item in the working nodeQueue
+	c.beat(worker, true)
 	key, quit := c.nodeQueue.Get()
 	if quit {
 		return false
 	}
+	c.beat(worker, false)
 
 	defer c.nodeQueue.Done(key)
 
 	err := c.syncNode(key.(string))
-	if err != nil {
+	if err != nil && !kerrors.IsConflict(err) {
 		c.metrics.SyncErrors.Inc()
 	}
 
@@ -102,12 +297,16 @@ func (c *Controller) processNextItem() bool {
 	return true
 }
 
+// getNode returns a copy of the node from the informer cache
 func (c *Controller) getNode(key string) (*corev1.Node, error) {
-	node, err := c.client.CoreV1().Nodes().Get(key, metav1.GetOptions{})
+	obj, exists, err := c.nodeIndexer.GetByKey(key)
 	if err != nil {
 		return nil, err
 	}
-	return node, nil
+	if !exists {
+		return nil, kerrors.NewNotFound(corev1.Resource("nodes"), key)
+	}
+	return obj.(*corev1.Node).DeepCopy(), nil
 }
 
 func (c *Controller) syncNode(key string) error {
@@ -115,18 +314,34 @@ func (c *Controller) syncNode(key string) error {
 	if err != nil {
 		if kerrors.IsNotFound(err) {
 			glog.V(6).Infof("Node %s got deleted", key)
+			c.instanceDetailsLock.Lock()
+			delete(c.instanceDetailsRefreshed, key)
+			c.instanceDetailsLock.Unlock()
+			c.persistLock.Lock()
+			delete(c.unpersisted, key)
+			c.persistLock.Unlock()
 			return nil
 		}
 		glog.V(0).Infof("Failed to fetch node %s: %v", key, err)
 		return nil
 	}
 
+	// The cache does not contain the result of a side effect which failed to persist, the node gets synced after the update
+	if flushed, err := c.flushUnpersisted(key); flushed || err != nil {
+		return err
+	}
+
+	if !c.ownsNode(node.Name) {
+		glog.V(8).Infof("Skipping node %s as it is assigned to another shard", node.Name)
+		return nil
+	}
+
 	isControllerNode, err := c.isControllerNode(node)
 	if err != nil {
 		return fmt.Errorf("failed to identify if node %s belongs to this controller: %v", node.Name, err)
 	}
 	if !isControllerNode {
-		glog.V(8).Infof("Skipping node %s as the specified node-controller != %s", node.Name, controllerName)
+		glog.V(8).Infof("Skipping node %s as the specified node-controller != %s", node.Name, c.controllerName)
 		return nil
 	}
 
@@ -148,6 +363,7 @@ func (c *Controller) syncNode(key string) error {
 		phase = phaseDeleting
 	}
 	node.Annotations[phaseAnnotationKey] = phase
+	since := phaseSince(node)
 
 	start := time.Now()
 
@@ -158,6 +374,8 @@ func (c *Controller) syncNode(key string) error {
 		node, err = c.syncProvisioningNode(node)
 	case phaseLaunching:
 		node, err = c.syncLaunchingNode(node)
+	case phaseRunning:
+		node, err = c.syncRunningNode(node)
 	case phaseDeleting:
 		node, err = c.syncDeletingNode(node)
 	}
@@ -166,25 +384,92 @@ func (c *Controller) syncNode(key string) error {
 		c.metrics.SyncSeconds.WithLabelValues(phase).Add(time.Since(start).Seconds())
 	}
 
+	sideEffect := c.takeSideEffect(key)
 	if err != nil {
 		return err
 	}
 
 	if node != nil {
-		return c.updateNode(originalData, node)
+		recordPhase(node, phase)
+		if err := c.writeNode(originalData, node, sideEffect); err != nil {
+			return err
+		}
+		c.observePhaseTransition(node, phase, since)
+		return nil
 	}
 
 	c.nodeQueue.AddAfter(key, 30*time.Second)
 	return nil
 }
 
+// getNodeClassConfig returns the config of the nodeclass with its parent & fragments resolved
 func (c *Controller) getNodeClassConfig(nc *v1alpha1.NodeClass) (*nodeclass.NodeClassConfig, error) {
+	return c.resolveNodeClassConfig(nc, []string{})
+}
+
+func (c *Controller) resolveNodeClassConfig(nc *v1alpha1.NodeClass, chain []string) (*nodeclass.NodeClassConfig, error) {
+	for _, name := range chain {
+		if name == nc.Name {
+			return nil, fmt.Errorf("cyclic nodeclass reference: %s -> %s", strings.Join(chain, " -> "), nc.Name)
+		}
+	}
+	chain = append(chain, nc.Name)
+
 	var config nodeclass.NodeClassConfig
 	err := json.Unmarshal(nc.Config.Raw, &config)
 	if err != nil {
-		return nil, fmt.Errorf("could not unmarshal config from nodeclass: %v", err)
+		return nil, fmt.Errorf("could not unmarshal config from nodeclass %s: %v", nc.Name, err)
+	}
+	if config.Parent == "" && len(config.Fragments) == 0 {
+		return &config, nil
 	}
-	return &config, nil
+
+	resolved := &nodeclass.NodeClassConfig{}
+	references := config.Fragments
+	if config.Parent != "" {
+		references = append([]string{config.Parent}, references...)
+	}
+	for _, name := range references {
+		class, err := c.getReferencedNodeClass(name)
+		if err != nil {
+			return nil, err
+		}
+		if class == nil {
+			return nil, &referencedNodeClassNotFoundErr{name: name, referrer: nc.Name}
+		}
+
+		referenced, err := c.resolveNodeClassConfig(class, chain)
+		if err != nil {
+			return nil, err
+		}
+		resolved = nodeclass.Merge(resolved, referenced)
+	}
+
+	return nodeclass.Merge(resolved, &config), nil
+}
+
+// getReferencedNodeClass returns the parent or fragment with the given name or nil if it does not exist.
+// References do not need to match the nodeclass label selector, uncached nodeclasses are read from the API server then.
+func (c *Controller) getReferencedNodeClass(name string) (*v1alpha1.NodeClass, error) {
+	obj, exists, err := c.nodeClassStore.GetByKey(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch nodeclass %s from store: %v", name, err)
+	}
+	if exists {
+		return obj.(*v1alpha1.NodeClass), nil
+	}
+	if c.getUncachedNodeClass == nil {
+		return nil, nil
+	}
+
+	class, err := c.getUncachedNodeClass(name)
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get nodeclass %s: %v", name, err)
+	}
+	return class, nil
 }
 
 func (c *Controller) getNodeClassFromAnnotationContent(node *corev1.Node) (*v1alpha1.NodeClass, *nodeclass.NodeClassConfig, error) {
@@ -215,7 +500,36 @@ func (c *Controller) getNodeClassFromAnnotation(node *corev1.Node) (*v1alpha1.No
 	return class, config, err
 }
 
+// getNodeClass returns the effective nodeclass of the node. That is the snapshot taken when the instance got created
+// or re-provisioned. Falls back to the current nodeclass if there is no snapshot.
+// A snapshot which fails to load is an error, the current nodeclass might differ from the one the instance got created with.
 func (c *Controller) getNodeClass(node *corev1.Node) (*v1alpha1.NodeClass, *nodeclass.NodeClassConfig, error) {
+	if node.Annotations[nodeClassSnapshotAnnotationKey] != "" {
+		snapshot, err := c.loadNodeClassSnapshot(node)
+		if err != nil {
+			return nil, nil, err
+		}
+		return snapshot.NodeClass(), &snapshot.Config, nil
+	}
+	return c.getLiveNodeClass(node)
+}
+
+// getLiveNodeClass returns the current nodeclass of the node with the node specific overrides applied
+func (c *Controller) getLiveNodeClass(node *corev1.Node) (*v1alpha1.NodeClass, *nodeclass.NodeClassConfig, error) {
+	class, config, err := c.getBaseNodeClass(node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config, err = applyNodeClassOverrides(node.Annotations[nodeClassOverridesAnnotationKey], config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid nodeclass overrides: %v", err)
+	}
+	return class, config, nil
+}
+
+// getBaseNodeClass returns the nodeclass of the node without the node specific overrides
+func (c *Controller) getBaseNodeClass(node *corev1.Node) (*v1alpha1.NodeClass, *nodeclass.NodeClassConfig, error) {
 	if node.Annotations[v1alpha1.NodeClassContentAnnotationKey] == "" && node.Annotations[v1alpha1.NodeClassNameAnnotationKey] == "" {
 		return nil, nil, noNodeClassDefinedErr
 	}
@@ -242,10 +556,59 @@ func (c *Controller) updateNode(originalData []byte, node *corev1.Node) error {
 		return nil
 	}
 
-	_, err = c.client.CoreV1().Nodes().Patch(node.Name, types.StrategicMergePatchType, b)
+	// The node got read from the cache. The resourceVersion makes the patch fail with a conflict
+	// if the node got changed in the meantime, instead of patching based on outdated data.
+	err = c.patchNode(node.Name, b, node.ResourceVersion)
+	if !kerrors.IsConflict(err) {
+		return err
+	}
+
+	// Status updates (e.g. heartbeats) change the resourceVersion as well. Those do not affect our patch,
+	// so it gets applied to the current node as long as its metadata & spec did not change.
+	original := &corev1.Node{}
+	if err := json.Unmarshal(originalData, original); err != nil {
+		return err
+	}
+	current, err := c.client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if !sameMetadataAndSpec(original, current) {
+		return kerrors.NewConflict(corev1.Resource("nodes"), node.Name, errors.New("the node got changed in the meantime"))
+	}
+	return c.patchNode(node.Name, b, current.ResourceVersion)
+}
+
+func (c *Controller) patchNode(name string, data []byte, resourceVersion string) error {
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+	metadata, ok := patch["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		patch["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, data)
 	return err
 }
 
+func sameMetadataAndSpec(a, b *corev1.Node) bool {
+	a, b = a.DeepCopy(), b.DeepCopy()
+	for _, n := range []*corev1.Node{a, b} {
+		n.ResourceVersion = ""
+		n.ManagedFields = nil
+		n.Status = corev1.NodeStatus{}
+	}
+	return apiequality.Semantic.DeepEqual(a, b)
+}
+
 // handleErr checks if an error happened and makes sure we will retry later.
 func (c *Controller) handleErr(err error, key interface{}) {
 	if err == nil {
@@ -253,20 +616,31 @@ func (c *Controller) handleErr(err error, key interface{}) {
 		// This ensures that future processing of updates for this key is not delayed because of
 		// an outdated error history.
 		c.nodeQueue.Forget(key)
+		c.forgetRetry(key)
 		return
 	}
 
 	// This controller retries 5 times if something goes wrong. After that, it stops trying.
+	// The node gets synced again with the next resync of the informer.
 	if c.nodeQueue.NumRequeues(key) < 5 {
+		// The cache was outdated. Retry once the cache got the current node.
+		if kerrors.IsConflict(err) {
+			glog.V(4).Infof("Conflict while syncing node %v, retrying: %v", key, err)
+			c.nodeQueue.AddRateLimited(key)
+			return
+		}
+
 		glog.V(0).Infof("Error syncing node %v: %v", key, err)
 
 		// Re-enqueue the key rate limited. Based on the rate limiter on the
 		// nodeQueue and the re-enqueue history, the key will be processed later again.
 		c.nodeQueue.AddRateLimited(key)
+		c.recordRetry(key, err)
 		return
 	}
 
 	c.nodeQueue.Forget(key)
+	c.forgetRetry(key)
 	// Report to an external entity that, even after several retries, we could not successfully process this key
 	runtime.HandleError(err)
 	glog.V(0).Infof("Dropping node %q out of the queue: %v", key, err)
@@ -277,39 +651,64 @@ func (c *Controller) Run(workerCount int, stopCh chan struct{}) {
 
 	// Let the workers stop when we are done
 	defer c.nodeQueue.ShutDown()
+	defer c.tombstoneQueue.ShutDown()
 	glog.V(0).Info("Starting Node controller")
 
 	go c.nodeInformer.Run(stopCh)
 	go c.nodeClassInformer.Run(stopCh)
+	go c.tombstoneInformer.Run(stopCh)
+	synced := []cache.InformerSynced{c.nodeInformer.HasSynced, c.nodeClassInformer.HasSynced, c.tombstoneInformer.HasSynced}
+	if c.leaseInformer != nil {
+		go c.leaseInformer.Run(stopCh)
+		synced = append(synced, c.leaseInformer.HasSynced)
+	}
 
 	// Wait for all involved caches to be synced, before processing items from the nodeQueue is started
-	if !cache.WaitForCacheSync(stopCh, c.nodeInformer.HasSynced, c.nodeClassInformer.HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, synced...) {
 		runtime.HandleError(errors.New("timed out waiting for caches to sync"))
 		return
 	}
 
-	go wait.Forever(func() {
-		c.metrics.Nodes.Set(float64(len(c.nodeIndexer.List())))
-	}, time.Second)
+	if c.shard != nil {
+		go c.shard.Run(stopCh)
+	}
+
+	go wait.Forever(c.updateNodeMetrics, time.Second)
+
+	c.heartbeatLock.Lock()
+	c.heartbeats = make([]workerHeartbeat, workerCount)
+	c.heartbeatLock.Unlock()
 
 	for i := 0; i < workerCount; i++ {
-		go wait.Until(c.runWorker, time.Second, stopCh)
+		worker := i
+		go wait.Until(func() { c.runWorker(worker) }, time.Second, stopCh)
 	}
 	go wait.Forever(c.readyConditionWorker, conditionUpdatePeriod)
 	go wait.Forever(c.migrationWorker, migrationWorkerPeriod)
+	go wait.Forever(c.hibernationWorker, hibernationWorkerPeriod)
+	go wait.Forever(c.stuckNodeWorker, stuckNodeWorkerPeriod)
+	for i := 0; i < tombstoneWorkerCount; i++ {
+		go wait.Until(func() { c.runTombstoneWorker(stopCh) }, time.Second, stopCh)
+	}
 
 	<-stopCh
 	glog.V(0).Info("Stopping Node controller")
-	glog.V(0).Info("Waiting until all pending migrations are done...")
-	c.waitUntilMigrationDone()
-	glog.V(0).Info("Done")
+	glog.V(0).Infof("Waiting up to %s until all pending migrations & deletions are done...", c.shutdownTimeout)
+	if c.waitForTombstones(c.shutdownTimeout) {
+		glog.V(0).Info("Done")
+	} else {
+		glog.V(0).Info("Timed out, the remaining migrations & deletions get resumed on the next start")
+	}
 }
 
-func (c *Controller) runWorker() {
-	for c.processNextItem() {
+func (c *Controller) runWorker(worker int) {
+	for c.processNextItem(worker) {
 	}
 }
 
 func (c *Controller) IsReady() bool {
-	return c.nodeInformer.HasSynced() && c.nodeClassInformer.HasSynced()
+	if c.leaseInformer != nil && !c.leaseInformer.HasSynced() {
+		return false
+	}
+	return c.nodeInformer.HasSynced() && c.nodeClassInformer.HasSynced() && c.tombstoneInformer.HasSynced()
 }
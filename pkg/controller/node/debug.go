@@ -0,0 +1,224 @@
+package node
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+)
+
+const redacted = "<redacted>"
+
+// secretFieldPatterns match the names of driver fields which contain credentials, e.g. AccessToken or SecretKey
+var secretFieldPatterns = []string{"token", "secret", "password", "passwd", "apikey", "accesskey", "privatekey", "credential"}
+
+type debugNode struct {
+	Name        string    `json:"name"`
+	Phase       string    `json:"phase"`
+	Provider    string    `json:"provider"`
+	NodeClass   string    `json:"nodeClass"`
+	PublicIP    string    `json:"publicIP"`
+	PhaseSince  time.Time `json:"phaseSince"`
+	TimeInPhase string    `json:"timeInPhase"`
+}
+
+type debugNodeDetails struct {
+	debugNode
+	DriverData interface{} `json:"driverData"`
+}
+
+type debugRetry struct {
+	Retries   int    `json:"retries"`
+	LastError string `json:"lastError"`
+}
+
+type debugQueue struct {
+	Depth   int                   `json:"depth"`
+	Retries map[string]debugRetry `json:"retries"`
+}
+
+type debugTombstone struct {
+	Node       string `json:"node"`
+	State      string `json:"state"`
+	Deadline   string `json:"deadline"`
+	InProgress bool   `json:"inProgress"`
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// DebugHandler serves a read-only JSON API with the state of the controller:
+//   - /debug/nodes: the nodes managed by this replica
+//   - /debug/nodes/<name>: a single node including its driver data, with credentials redacted
+//   - /debug/queue: the depth of the work queue and the nodes which are retried after errors
+//   - /debug/tombstones: the pending migrations & deletions
+func (c *Controller) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/nodes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.debugNodes())
+	})
+	mux.HandleFunc("/debug/nodes/", func(w http.ResponseWriter, r *http.Request) {
+		details, found := c.debugNodeDetails(strings.TrimPrefix(r.URL.Path, "/debug/nodes/"))
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, details)
+	})
+	mux.HandleFunc("/debug/queue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.debugQueue())
+	})
+	mux.HandleFunc("/debug/tombstones", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.debugTombstones())
+	})
+	return mux
+}
+
+func (c *Controller) debugNodes() []debugNode {
+	nodes := []debugNode{}
+	for _, obj := range c.nodeIndexer.List() {
+		node := obj.(*v1.Node)
+		if !c.ownsNode(node.Name) {
+			continue
+		}
+		if isControllerNode, err := c.isControllerNode(node); err != nil || !isControllerNode {
+			continue
+		}
+		nodes = append(nodes, c.newDebugNode(node))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func (c *Controller) newDebugNode(node *v1.Node) debugNode {
+	provider, _ := c.nodeMetricLabels(node)
+	since := phaseSince(node)
+	return debugNode{
+		Name:        node.Name,
+		Phase:       node.Annotations[phaseAnnotationKey],
+		Provider:    provider,
+		NodeClass:   node.Annotations[v1alpha1.NodeClassNameAnnotationKey],
+		PublicIP:    node.Annotations[publicIPAnnotationKey],
+		PhaseSince:  since,
+		TimeInPhase: time.Since(since).Round(time.Second).String(),
+	}
+}
+
+func (c *Controller) debugNodeDetails(name string) (*debugNodeDetails, bool) {
+	obj, exists, err := c.nodeIndexer.GetByKey(name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	node := obj.(*v1.Node)
+
+	details := &debugNodeDetails{debugNode: c.newDebugNode(node)}
+	if data := node.Annotations[driverDataAnnotationKey]; data != "" {
+		var driverData interface{}
+		if err := json.Unmarshal([]byte(data), &driverData); err != nil {
+			details.DriverData = "invalid driver data: " + err.Error()
+		} else {
+			details.DriverData = redact(driverData)
+		}
+	}
+	return details, true
+}
+
+func (c *Controller) debugQueue() debugQueue {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	retries := map[string]debugRetry{}
+	for key, r := range c.retries {
+		retries[key] = r
+	}
+	return debugQueue{
+		Depth:   c.nodeQueue.Len(),
+		Retries: retries,
+	}
+}
+
+func (c *Controller) debugTombstones() []debugTombstone {
+	c.tombstoneLock.Lock()
+	defer c.tombstoneLock.Unlock()
+
+	tombstones := []debugTombstone{}
+	for _, obj := range c.tombstoneStore.List() {
+		secret := obj.(*v1.Secret)
+		node, deadline, err := decodeTombstone(secret)
+		if err != nil {
+			continue
+		}
+		state := "deleting"
+		if until, err := time.Parse(time.RFC3339, deadline); err == nil && time.Now().Before(until) {
+			state = "waiting-for-migration"
+		}
+		tombstones = append(tombstones, debugTombstone{
+			Node:       node.Name,
+			State:      state,
+			Deadline:   deadline,
+			InProgress: c.tombstonesInProgress[secret.Name],
+			LastError:  c.tombstonesFailing[secret.Name],
+		})
+	}
+	return tombstones
+}
+
+// recordRetry remembers that the sync of the key failed and gets retried
+func (c *Controller) recordRetry(key interface{}, err error) {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	c.retries[key.(string)] = debugRetry{Retries: c.nodeQueue.NumRequeues(key), LastError: err.Error()}
+}
+
+func (c *Controller) forgetRetry(key interface{}) {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	delete(c.retries, key.(string))
+}
+
+// redact replaces the values of all fields which look like credentials
+func redact(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if isSecretField(k) {
+				value[k] = redacted
+			} else {
+				value[k] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redact(value[i])
+		}
+	}
+	return v
+}
+
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	if name == "rawdriver" {
+		return true
+	}
+	for _, p := range secretFieldPatterns {
+		if strings.Contains(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,75 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+const (
+	mirrorPodAnnotationKey = "kubernetes.io/config.mirror"
+)
+
+// drainNode evicts all pods from the given node, except mirror & DaemonSet pods.
+// Returns true if no pods are left which need to be evicted.
+func (c *Controller) drainNode(node *v1.Node) (bool, error) {
+	pods, err := c.client.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{"spec.nodeName": node.Name}).String(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list pods of node %s: %v", node.Name, err)
+	}
+
+	drained := true
+	for _, pod := range pods.Items {
+		if !needsEviction(&pod) {
+			continue
+		}
+		drained = false
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		glog.V(6).Infof("Evicting pod %s/%s from node %s", pod.Namespace, pod.Name, node.Name)
+		err := c.client.CoreV1().Pods(pod.Namespace).Evict(&policy.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+			},
+		})
+		if err != nil {
+			if kerrors.IsNotFound(err) {
+				continue
+			}
+			// Evictions which would violate a PodDisruptionBudget get rejected with 429. We just try it again later.
+			if kerrors.IsTooManyRequests(err) {
+				glog.V(4).Infof("Eviction of pod %s/%s got rejected: %v", pod.Namespace, pod.Name, err)
+				continue
+			}
+			return false, fmt.Errorf("failed to evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+
+	return drained, nil
+}
+
+func needsEviction(pod *v1.Pod) bool {
+	if _, isMirrorPod := pod.Annotations[mirrorPodAnnotationKey]; isMirrorPod {
+		return false
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Controller != nil && *ref.Controller && ref.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// workerHeartbeat is the last time a worker made progress. Idle workers wait for new items in the queue.
+type workerHeartbeat struct {
+	last time.Time
+	idle bool
+}
+
+// beat records the progress of the worker
+func (c *Controller) beat(worker int, idle bool) {
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+
+	c.heartbeats[worker] = workerHeartbeat{last: time.Now(), idle: idle}
+}
+
+// Live returns an error if the queue is not empty and none of the workers made progress within the stall timeout
+func (c *Controller) Live() error {
+	if c.workerStallTimeout == 0 || c.nodeQueue.Len() == 0 {
+		return nil
+	}
+
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+
+	// The workers are not started before the caches are synced
+	if len(c.heartbeats) == 0 {
+		return nil
+	}
+
+	var latest time.Time
+	for _, hb := range c.heartbeats {
+		if hb.idle || time.Since(hb.last) < c.workerStallTimeout {
+			return nil
+		}
+		if hb.last.After(latest) {
+			latest = hb.last
+		}
+	}
+	return fmt.Errorf("none of the %d workers made progress since %s while %d nodes are queued", len(c.heartbeats), latest.UTC().Format(time.RFC3339), c.nodeQueue.Len())
+}
+
+// Ready returns an error if the caches are not synced or this replica is not assigned to a shard.
+// kube-machine has no leader election: Without sharding every replica processes all nodes, with sharding a replica
+// processes its share of the nodes as long as it holds a valid shard Lease. Therefore shard membership is what
+// leadership would be for a leader elected controller.
+func (c *Controller) Ready() error {
+	if !c.IsReady() {
+		return errors.New("caches are not synced")
+	}
+	if c.shard != nil && !c.shard.IsMember() {
+		return errors.New("not a member of the shards")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package node
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// hibernationWorker stops all nodes which are in a hibernation window of their node class
+// and starts them again after the window. Nodes with the protection annotation do not enter hibernation,
+// hibernated nodes are always woken up once they are protected or their nodeclass has no hibernation anymore.
+// The power state specified by users is kept, so nodes which got turned off manually stay off after the window.
+func (c *Controller) hibernationWorker() {
+	now := time.Now()
+
+	nlist := c.nodeIndexer.List()
+	for _, obj := range nlist {
+		node := obj.(*v1.Node)
+		if node.Annotations[phaseAnnotationKey] != phaseRunning || node.DeletionTimestamp != nil {
+			continue
+		}
+		if !c.ownsNode(node.Name) {
+			continue
+		}
+
+		isControllerNode, err := c.isControllerNode(node)
+		if err != nil {
+			glog.V(0).Infof("failed to identify if node %s belongs to this controller: %v", node.Name, err)
+			continue
+		}
+		if !isControllerNode {
+			continue
+		}
+
+		// Hibernation is an operational setting, which is always taken from the current nodeclass
+		_, config, err := c.getLiveNodeClass(node)
+		if err != nil {
+			glog.V(0).Infof("Failed to get nodeclass for node %s: %v", node.Name, err)
+			continue
+		}
+
+		hibernate := false
+		if config.Hibernation != nil && node.Annotations[hibernationProtectedAnnotationKey] != "true" {
+			hibernate, err = config.Hibernation.InWindow(now)
+			if err != nil {
+				glog.V(0).Infof("Invalid hibernation schedule for node %s: %v", node.Name, err)
+				continue
+			}
+		}
+
+		hibernated := node.Annotations[hibernatedAnnotationKey] != ""
+		switch {
+		case hibernate && !hibernated:
+			glog.V(2).Infof("Hibernating node %s", node.Name)
+			err = c.patchHibernation(node, "true", "")
+		case !hibernate && hibernated:
+			glog.V(2).Infof("Waking up node %s from hibernation", node.Name)
+			// Without a power state specified by users, the instance would stay stopped
+			powerState := ""
+			if node.Annotations[powerStateAnnotationKey] == "" {
+				powerState = powerStateOn
+			}
+			err = c.patchHibernation(node, "", powerState)
+		}
+		if err != nil {
+			glog.V(0).Infof("Failed to update hibernation of node %s: %v", node.Name, err)
+		}
+	}
+}
+
+// patchHibernation marks the node as hibernated, which makes it off, and optionally sets the power state.
+// The actual power operation is done in the running phase.
+func (c *Controller) patchHibernation(node *v1.Node, hibernated, powerState string) error {
+	annotations := map[string]interface{}{
+		hibernatedAnnotationKey: hibernated,
+	}
+	if hibernated == "" {
+		annotations[hibernatedAnnotationKey] = nil
+	}
+	if powerState != "" {
+		annotations[powerStateAnnotationKey] = powerState
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.client.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, patch)
+	return err
+}
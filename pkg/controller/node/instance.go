@@ -0,0 +1,140 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/golang/glog"
+	nodehelper "github.com/kube-node/kube-machine/pkg/node"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/kube-machine/pkg/options"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	LabelZoneRegion        = "failure-domain.beta.kubernetes.io/region"
+	LabelZoneFailureDomain = "failure-domain.beta.kubernetes.io/zone"
+	LabelInstanceType      = "beta.kubernetes.io/instance-type"
+
+	instanceDetailsRefreshPeriod = 5 * time.Minute
+)
+
+// setInstanceDetails sets the ip & hostname annotations, the providerID and the topology labels of the node.
+// Returns true if the node got changed.
+func setInstanceDetails(node *v1.Node, h *host.Host, config *nodeclass.NodeClassConfig) (bool, error) {
+	ip, err := h.Driver.GetIP()
+	if err != nil {
+		return false, fmt.Errorf("could not get public ip: %v", err)
+	}
+	hostname, err := h.Driver.GetSSHHostname()
+	if err != nil {
+		return false, fmt.Errorf("could not get hostname: %v", err)
+	}
+
+	var changed bool
+	set := func(m map[string]string, key, value string) {
+		if value != "" && m[key] != value {
+			m[key] = value
+			changed = true
+		}
+	}
+
+	set(node.Annotations, publicIPAnnotationKey, ip)
+	set(node.Annotations, hostnameAnnotationKey, hostname)
+
+	// The providerID is immutable once it got set
+	if node.Spec.ProviderID == "" {
+		node.Spec.ProviderID = fmt.Sprintf("%s://%s", h.DriverName, h.Name)
+		changed = true
+	}
+
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	topology := options.GetTopology(config.Provider, config.DockerMachineFlags)
+	set(node.Labels, LabelZoneRegion, topology.Region)
+	set(node.Labels, LabelZoneFailureDomain, topology.Zone)
+	set(node.Labels, LabelInstanceType, topology.InstanceType)
+
+	return changed, nil
+}
+
+// getNodeAddresses returns the node addresses derived from the ip & hostname annotations
+func getNodeAddresses(node *v1.Node) []v1.NodeAddress {
+	var addresses []v1.NodeAddress
+	if ip := node.Annotations[publicIPAnnotationKey]; ip != "" {
+		addrType := v1.NodeExternalIP
+		if isPrivateIP(net.ParseIP(ip)) {
+			addrType = v1.NodeInternalIP
+		}
+		addresses = append(addresses, v1.NodeAddress{Type: addrType, Address: ip})
+	}
+	if hostname := node.Annotations[hostnameAnnotationKey]; hostname != "" {
+		addresses = append(addresses, v1.NodeAddress{Type: v1.NodeHostName, Address: hostname})
+	}
+	return addresses
+}
+
+// syncNodeAddresses writes the addresses of the instance into the node status.
+// Once the kubelet joined, it is the owner of the addresses and we leave them untouched.
+func (c *Controller) syncNodeAddresses(node *v1.Node) error {
+	if nodehelper.KubeletReported(node) {
+		return nil
+	}
+
+	addresses := getNodeAddresses(node)
+	if len(addresses) == 0 || reflect.DeepEqual(addresses, node.Status.Addresses) {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"addresses": addresses,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	glog.V(6).Infof("Updating addresses of node %s", node.Name)
+	_, err = c.client.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, patch, "status")
+	if err != nil {
+		return fmt.Errorf("failed to update addresses of node %s: %v", node.Name, err)
+	}
+	return nil
+}
+
+// needsInstanceDetailsRefresh returns true if the instance details of the node were not refreshed within the refresh period
+func (c *Controller) needsInstanceDetailsRefresh(node *v1.Node) bool {
+	c.instanceDetailsLock.Lock()
+	defer c.instanceDetailsLock.Unlock()
+
+	return time.Since(c.instanceDetailsRefreshed[node.Name]) >= instanceDetailsRefreshPeriod
+}
+
+// markInstanceDetailsRefreshed records a successful refresh. Failed refreshes are retried with the next sync.
+func (c *Controller) markInstanceDetailsRefreshed(node *v1.Node) {
+	c.instanceDetailsLock.Lock()
+	defer c.instanceDetailsLock.Unlock()
+
+	c.instanceDetailsRefreshed[node.Name] = time.Now()
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, block, _ := net.ParseCIDR(cidr)
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,8 +1,13 @@
 package node
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
 	nodehelper "github.com/kube-node/kube-machine/pkg/node"
 
+	coordinationv1 "k8s.io/api/coordination/v1"
 	"k8s.io/api/core/v1"
 )
 
@@ -16,7 +21,19 @@ func (c *Controller) syncLaunchingNode(node *v1.Node) (changedN *v1.Node, err er
 }
 
 func (c *Controller) syncLaunchingHeartbeat(node *v1.Node) (*v1.Node, error) {
-	if !nodehelper.HasJoined(node) {
+	lease, err := c.getKubeletLease(node)
+	if err != nil {
+		return nil, err
+	}
+	if !nodehelper.HasJoined(node, lease, c.kubeletLeaseHeartbeat) {
+		return nil, nil
+	}
+
+	stable, changed := c.isJoinStable(node)
+	if !stable {
+		if changed {
+			return node, nil
+		}
 		return nil, nil
 	}
 
@@ -29,3 +46,40 @@ func (c *Controller) syncLaunchingHeartbeat(node *v1.Node) (*v1.Node, error) {
 	node.Annotations[phaseAnnotationKey] = phaseRunning
 	return node, nil
 }
+
+// isJoinStable returns true if the machine & boot id reported by the kubelet did not change within the join stability period.
+// A change restarts the period. Returns as well if the node got changed.
+func (c *Controller) isJoinStable(node *v1.Node) (stable bool, changed bool) {
+	if c.joinStabilityPeriod == 0 {
+		return true, false
+	}
+
+	id := fmt.Sprintf("%s/%s", node.Status.NodeInfo.MachineID, node.Status.NodeInfo.BootID)
+	joined, err := time.Parse(time.RFC3339, node.Annotations[joinedAnnotationKey])
+	if err != nil || node.Annotations[joinedBootIDAnnotationKey] != id {
+		if node.Annotations[joinedBootIDAnnotationKey] != "" {
+			glog.V(2).Infof("Machine or boot id of node %s changed from %s to %s, restarting the join stability period", node.Name, node.Annotations[joinedBootIDAnnotationKey], id)
+		}
+		node.Annotations[joinedAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+		node.Annotations[joinedBootIDAnnotationKey] = id
+		return false, true
+	}
+
+	return time.Since(joined) >= c.joinStabilityPeriod, false
+}
+
+// getKubeletLease returns the cached Lease of the node in kube-node-lease or nil if it does not exist.
+// Leases are only cached with the kubelet lease heartbeat, as they are not needed otherwise.
+func (c *Controller) getKubeletLease(node *v1.Node) (*coordinationv1.Lease, error) {
+	if c.leaseStore == nil {
+		return nil, nil
+	}
+	obj, exists, err := c.leaseStore.GetByKey(kubeletLeaseNamespace + "/" + node.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubelet lease of node %s from store: %v", node.Name, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return obj.(*coordinationv1.Lease), nil
+}
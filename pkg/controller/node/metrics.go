@@ -2,6 +2,7 @@ package node
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,8 +13,28 @@ type ControllerMetrics struct {
 	Nodes       prometheus.Gauge
 	SyncErrors  prometheus.Counter
 	SyncSeconds *prometheus.CounterVec
+	Heartbeats  *prometheus.CounterVec
+
+	PhaseSeconds       *prometheus.HistogramVec
+	TimeToReadySeconds *prometheus.HistogramVec
+	PhaseNodes         *prometheus.GaugeVec
+	OldestNodeSeconds  *prometheus.GaugeVec
+	Machines           *prometheus.CounterVec
+	DriverCallSeconds  *prometheus.HistogramVec
+	StuckNodes         *prometheus.GaugeVec
+	FailingTombstones  prometheus.Gauge
 }
 
+const (
+	machineEventCreated  = "created"
+	machineEventDeleted  = "deleted"
+	machineEventFailed   = "failed"
+	machineEventMigrated = "migrated"
+)
+
+// lifecycleBuckets range from 10 seconds to about 2 hours
+var lifecycleBuckets = prometheus.ExponentialBuckets(10, 2, 10)
+
 func NewControllerMetrics() *ControllerMetrics {
 	nodes := prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: "kubemachine",
@@ -33,15 +54,91 @@ func NewControllerMetrics() *ControllerMetrics {
 		Help:      "Total time spend syncing in a phase in seconds",
 	}, []string{"phase"})
 
-	prometheus.MustRegister(nodes, syncErrors, syncSeconds)
+	heartbeats := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "heartbeats_total",
+		Help:      "Total number of heartbeats done on behalf of not yet joined kubelets, by action (condition, lease or removed)",
+	}, []string{"action"})
+
+	phaseSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "phase_duration_seconds",
+		Help:      "Time nodes spent in the pending (create), provisioning & launching phase",
+		Buckets:   lifecycleBuckets,
+	}, []string{"phase", "provider", "node_class"})
+	timeToReadySeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "time_to_ready_seconds",
+		Help:      "Time from the creation of a node until it is running",
+		Buckets:   lifecycleBuckets,
+	}, []string{"provider", "node_class"})
+	phaseNodes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "phase_nodes",
+		Help:      "Number of nodes per phase",
+	}, []string{"phase"})
+	oldestNodeSeconds := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "oldest_node_phase_seconds",
+		Help:      "Time the oldest node of each non-running phase is in this phase",
+	}, []string{"phase"})
+	machines := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "machines_total",
+		Help:      "Total number of machines by event (created, deleted, failed or migrated)",
+	}, []string{"event"})
+	driverCallSeconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kubemachine",
+		Subsystem: "driver",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of docker-machine driver calls by driver & method",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"driver", "method"})
+	stuckNodes := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "stuck_nodes",
+		Help:      "Number of nodes which exceeded the timeout of their phase",
+	}, []string{"phase"})
+
+	failingTombstones := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "kubemachine",
+		Subsystem: "controller",
+		Name:      "failing_tombstones",
+		Help:      "Number of deleted nodes which repeatedly failed to get migrated or to get their instance deleted",
+	})
+
+	prometheus.MustRegister(nodes, syncErrors, syncSeconds, heartbeats,
+		phaseSeconds, timeToReadySeconds, phaseNodes, oldestNodeSeconds, machines, driverCallSeconds, stuckNodes, failingTombstones)
 
 	return &ControllerMetrics{
 		Nodes:       nodes,
 		SyncErrors:  syncErrors,
 		SyncSeconds: syncSeconds,
+		Heartbeats:  heartbeats,
+
+		PhaseSeconds:       phaseSeconds,
+		TimeToReadySeconds: timeToReadySeconds,
+		PhaseNodes:         phaseNodes,
+		OldestNodeSeconds:  oldestNodeSeconds,
+		Machines:           machines,
+		DriverCallSeconds:  driverCallSeconds,
+		StuckNodes:         stuckNodes,
+		FailingTombstones:  failingTombstones,
 	}
 }
 
+// ObserveDriverCall records the duration of a driver call. It is meant to be used as libmachine.DriverCallObserver.
+func (cm *ControllerMetrics) ObserveDriverCall(driverName, method string, duration time.Duration, err error) {
+	cm.DriverCallSeconds.WithLabelValues(driverName, method).Observe(duration.Seconds())
+}
+
 func (cm *ControllerMetrics) Serve(addr string) {
 	if addr != "" {
 		http.Handle("/metrics", promhttp.Handler())
@@ -3,14 +3,14 @@ package node
 import (
 	"encoding/json"
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/golang/glog"
 	nodehelper "github.com/kube-node/kube-machine/pkg/node"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
@@ -39,26 +39,35 @@ func (c *Controller) migrateNode(srcNode, targetNode *v1.Node) error {
 		targetNode.Finalizers = append(targetNode.Finalizers, deleteFinalizerName)
 	}
 
-	return c.updateNode(originalData, targetNode)
-}
+	err = c.updateNode(originalData, targetNode)
+	if err != nil {
+		return err
+	}
 
-func (c *Controller) waitUntilMigrationDone() {
-	for {
-		if len(pendingMigrationNodes) == 0 {
-			return
-		}
-		glog.V(2).Infof("%d nodes are still pending for migration", len(pendingMigrationNodes))
-		time.Sleep(migrationCheckInterval)
+	// Keep the snapshot alive after the source node got deleted
+	if err := c.addSnapshotOwner(targetNode); err != nil {
+		glog.V(0).Infof("Failed to add node %s as owner of its nodeclass snapshot: %v", targetNode.Name, err)
 	}
+	return nil
 }
 
-// findForeignSibling returns a node which is the sibling of the given node
+// findForeignSibling returns a node which is the sibling of the given node and the method it got matched by.
 // Necessary in case the kubelet deletes the node-controller managed node & creates a new one...
-// Then we need to migrate.
-func (c *Controller) findForeignSibling(node *v1.Node) (*v1.Node, error) {
-	nlist := c.nodeIndexer.List()
-	for _, obj := range nlist {
-		candidate := obj.(*v1.Node)
+// Then we need to migrate. The returned node is a copy and can be modified.
+func (c *Controller) findForeignSibling(node *v1.Node) (*v1.Node, string, error) {
+	var migration *nodeclass.NodeClassMigration
+	if _, config, err := c.getNodeClass(node); err == nil {
+		migration = config.Migration
+	} else {
+		glog.V(4).Infof("Failed to get nodeclass of node %s, using all match methods: %v", node.Name, err)
+	}
+	methods := migration.MatchMethods()
+
+	candidates, err := c.listSiblingCandidates()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, candidate := range candidates {
 		if candidate.UID == node.UID {
 			continue
 		}
@@ -73,21 +82,76 @@ func (c *Controller) findForeignSibling(node *v1.Node) (*v1.Node, error) {
 			continue
 		}
 
-		//Either if the name or the hostname label key is the same
-		if candidate.Name == node.Name {
-			glog.V(6).Infof("Found a matching node via name comparison. Deleted: %q New: %q", node.Name, candidate.Name)
-			return candidate, nil
+		for _, method := range methods {
+			if isSibling(node, candidate, method) {
+				glog.V(6).Infof("Found a matching node via %s comparison. Deleted: %q New: %q", method, node.Name, candidate.Name)
+				return candidate.DeepCopy(), method, nil
+			}
+		}
+	}
+	return nil, "", nodeNotFoundErr
+}
+
+// listSiblingCandidates returns all nodes. The kubelet does not necessarily register its node with labels matching
+// the node label selector, therefore the nodes are listed from the API server instead of the cache if one is set.
+func (c *Controller) listSiblingCandidates() ([]*v1.Node, error) {
+	if c.nodeLabelSelector == "" {
+		nodes := []*v1.Node{}
+		for _, obj := range c.nodeIndexer.List() {
+			nodes = append(nodes, obj.(*v1.Node))
+		}
+		return nodes, nil
+	}
+
+	// ResourceVersion 0 serves the list from the watch cache of the API server
+	list, err := c.client.CoreV1().Nodes().List(metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	nodes := []*v1.Node{}
+	for i := range list.Items {
+		nodes = append(nodes, &list.Items[i])
+	}
+	return nodes, nil
+}
+
+// isSibling returns true if the candidate is the node registered by the kubelet running on the machine of the given node
+func isSibling(node, candidate *v1.Node, method string) bool {
+	switch method {
+	case nodeclass.MatchByName:
+		return candidate.Name == node.Name
+	case nodeclass.MatchByHostname:
+		return candidate.Labels[LabelHostname] != "" && candidate.Labels[LabelHostname] == node.Labels[LabelHostname]
+	case nodeclass.MatchByAddress:
+		ip := node.Annotations[publicIPAnnotationKey]
+		if ip == "" {
+			return false
 		}
-		if candidate.ObjectMeta.Labels[LabelHostname] != "" && candidate.ObjectMeta.Labels[LabelHostname] == node.ObjectMeta.Labels[LabelHostname] {
-			glog.V(6).Infof("Found a matching node via hostname-label comparison. Deleted: %q New: %q", node.ObjectMeta.Labels[LabelHostname], candidate.ObjectMeta.Labels[LabelHostname])
-			return candidate, nil
+		for _, addr := range candidate.Status.Addresses {
+			if (addr.Type == v1.NodeExternalIP || addr.Type == v1.NodeInternalIP) && addr.Address == ip {
+				return true
+			}
 		}
+		return false
+	case nodeclass.MatchByProviderID:
+		return candidate.Spec.ProviderID != "" && candidate.Spec.ProviderID == node.Spec.ProviderID
+	case nodeclass.MatchBySystemUUID:
+		uuid := node.Annotations[systemUUIDAnnotationKey]
+		return uuid != "" && strings.EqualFold(candidate.Status.NodeInfo.SystemUUID, uuid)
 	}
-	return nil, nodeNotFoundErr
+	return false
 }
 
 func (c *Controller) isControllerNode(node *v1.Node) (bool, error) {
-	class, _, err := c.getNodeClass(node)
+	if node.Annotations[nodeClassSnapshotAnnotationKey] != "" {
+		snapshot, err := c.loadNodeClassSnapshot(node)
+		if err != nil {
+			return false, err
+		}
+		return snapshot.NodeController == c.controllerName, nil
+	}
+
+	class, _, err := c.getBaseNodeClass(node)
 	if err != nil {
 		if err == noNodeClassDefinedErr {
 			return false, nil
@@ -95,13 +159,24 @@ func (c *Controller) isControllerNode(node *v1.Node) (bool, error) {
 		return false, fmt.Errorf("failed to get nodeclass for node %s: %v", node.Name, err)
 	}
 
-	return class.NodeController == controllerName, nil
+	return class.NodeController == c.controllerName, nil
+}
+
+// ManagesNode returns true if the node was created by kube-machine and its nodeclass belongs to this controller
+func (c *Controller) ManagesNode(node *v1.Node) (bool, error) {
+	if node.Annotations[driverDataAnnotationKey] == "" {
+		return false, nil
+	}
+	return c.isControllerNode(node)
 }
 
 func (c *Controller) migrationWorker() {
 	nlist := c.nodeIndexer.List()
 	for _, obj := range nlist {
 		node := obj.(*v1.Node)
+		if !c.ownsNode(node.Name) {
+			continue
+		}
 		//Only check for nodes for this controller
 		isControllerNode, err := c.isControllerNode(node)
 		if err != nil {
@@ -109,13 +184,13 @@ func (c *Controller) migrationWorker() {
 			continue
 		}
 		if !isControllerNode {
-			glog.V(8).Infof("Skipping node %s as the specified node-controller != %s", node.Name, controllerName)
+			glog.V(8).Infof("Skipping node %s as the specified node-controller != %s", node.Name, c.controllerName)
 			continue
 		}
 
 		glog.V(8).Infof("Processing node %s for migration check", node.Name)
 
-		sibling, err := c.findForeignSibling(node)
+		sibling, method, err := c.findForeignSibling(node)
 		if err != nil {
 			if err != nodeNotFoundErr {
 				glog.V(0).Infof("Failed to find a sibling for %s: %v", node.Name, err)
@@ -124,6 +199,7 @@ func (c *Controller) migrationWorker() {
 		}
 		if node.ObjectMeta.CreationTimestamp.Before(&sibling.ObjectMeta.CreationTimestamp) {
 			glog.V(4).Infof("Found a suitable sibling for node %s to migrate. Deleting now %s to trigger the migration", node.Name, node.Name)
+			c.recorder.Eventf(node, v1.EventTypeNormal, "SiblingFound", "Node %s got registered by the kubelet of this machine (matched by %s), deleting this node to migrate", sibling.Name, method)
 			err := c.client.CoreV1().Nodes().Delete(node.Name, &metav1.DeleteOptions{})
 			if err != nil {
 				glog.V(0).Infof("Failed to delete node %s for migration: %v", node.Name, err)
@@ -133,23 +209,3 @@ func (c *Controller) migrationWorker() {
 		}
 	}
 }
-
-func (c *Controller) deleteMigrationWatcher(node *v1.Node) wait.ConditionFunc {
-	return func() (done bool, err error) {
-		newNode, err := c.findForeignSibling(node)
-		if err != nil {
-			if err != nodeNotFoundErr {
-				glog.V(0).Infof("Failed to fetch node %s during migration check: %v", node.Name, err)
-			}
-			return false, nil
-		}
-		err = c.migrateNode(node, newNode)
-		if err != nil {
-			glog.V(0).Infof("Failed to migrate node %s: %v", node.Name, err)
-			return false, nil
-		}
-		glog.V(4).Infof("Migrated node %s to %s", node.Name, newNode.Name)
-		delete(node.Annotations, driverDataAnnotationKey)
-		return true, nil
-	}
-}
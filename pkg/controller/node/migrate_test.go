@@ -0,0 +1,182 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestIsSibling(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "kube-machine-1",
+			Labels: map[string]string{LabelHostname: "machine-1"},
+			Annotations: map[string]string{
+				publicIPAnnotationKey:   "192.0.2.10",
+				systemUUIDAnnotationKey: "EC2A1B2C-0000-1111-2222-333344445555",
+			},
+		},
+		Spec: v1.NodeSpec{ProviderID: "digitalocean://kube-machine-1"},
+	}
+	nodeWithoutDetails := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kube-machine-2"}}
+
+	tests := []struct {
+		name      string
+		node      *v1.Node
+		candidate *v1.Node
+		method    string
+		expected  bool
+	}{
+		{
+			name:      "same name",
+			node:      node,
+			candidate: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kube-machine-1"}},
+			method:    nodeclass.MatchByName,
+			expected:  true,
+		},
+		{
+			name:      "different name",
+			node:      node,
+			candidate: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "machine-1"}},
+			method:    nodeclass.MatchByName,
+		},
+		{
+			name:      "same hostname",
+			node:      node,
+			candidate: &v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{LabelHostname: "machine-1"}}},
+			method:    nodeclass.MatchByHostname,
+			expected:  true,
+		},
+		{
+			name:      "without hostnames",
+			node:      nodeWithoutDetails,
+			candidate: &v1.Node{},
+			method:    nodeclass.MatchByHostname,
+		},
+		{
+			name: "same address",
+			node: node,
+			candidate: &v1.Node{Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.2"}, {Type: v1.NodeExternalIP, Address: "192.0.2.10"}},
+			}},
+			method:   nodeclass.MatchByAddress,
+			expected: true,
+		},
+		{
+			name: "same address with another system uuid",
+			node: node,
+			candidate: &v1.Node{Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "192.0.2.10"}},
+				NodeInfo:  v1.NodeSystemInfo{SystemUUID: "00000000-0000-0000-0000-000000000000"},
+			}},
+			method:   nodeclass.MatchByAddress,
+			expected: true,
+		},
+		{
+			name:      "without addresses",
+			node:      nodeWithoutDetails,
+			candidate: &v1.Node{},
+			method:    nodeclass.MatchByAddress,
+		},
+		{
+			name: "same address of another type",
+			node: node,
+			candidate: &v1.Node{Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeHostName, Address: "192.0.2.10"}},
+			}},
+			method: nodeclass.MatchByAddress,
+		},
+		{
+			name:      "same providerID",
+			node:      node,
+			candidate: &v1.Node{Spec: v1.NodeSpec{ProviderID: "digitalocean://kube-machine-1"}},
+			method:    nodeclass.MatchByProviderID,
+			expected:  true,
+		},
+		{
+			name:      "different providerID",
+			node:      node,
+			candidate: &v1.Node{Spec: v1.NodeSpec{ProviderID: "digitalocean://kube-machine-2"}},
+			method:    nodeclass.MatchByProviderID,
+		},
+		{
+			name:      "without providerIDs",
+			node:      nodeWithoutDetails,
+			candidate: &v1.Node{},
+			method:    nodeclass.MatchByProviderID,
+		},
+		{
+			name: "same system uuid",
+			node: node,
+			candidate: &v1.Node{Status: v1.NodeStatus{
+				NodeInfo: v1.NodeSystemInfo{SystemUUID: "ec2a1b2c-0000-1111-2222-333344445555"},
+			}},
+			method:   nodeclass.MatchBySystemUUID,
+			expected: true,
+		},
+		{
+			name:      "without system uuids",
+			node:      nodeWithoutDetails,
+			candidate: &v1.Node{},
+			method:    nodeclass.MatchBySystemUUID,
+		},
+		{
+			name:      "unknown method",
+			node:      node,
+			candidate: &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kube-machine-1"}},
+			method:    "labels",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := isSibling(test.node, test.candidate, test.method); matched != test.expected {
+				t.Errorf("expected %t, got %t", test.expected, matched)
+			}
+		})
+	}
+}
+
+func TestListSiblingCandidates(t *testing.T) {
+	managed := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kube-machine-1", Labels: map[string]string{"pool": "workers"}}}
+	registered := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-0-2"}}
+
+	// The cache only contains the nodes matching the node label selector
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(managed)
+	client := fake.NewSimpleClientset(managed, registered)
+
+	tests := []struct {
+		name     string
+		selector string
+		expected int
+	}{
+		{
+			name:     "without selector from the cache",
+			expected: 1,
+		},
+		{
+			name:     "with selector from the API server",
+			selector: "pool=workers",
+			expected: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Controller{client: client, nodeIndexer: indexer, nodeLabelSelector: test.selector}
+			nodes, err := c.listSiblingCandidates()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(nodes) != test.expected {
+				t.Errorf("expected %d nodes, got %d", test.expected, len(nodes))
+			}
+		})
+	}
+}
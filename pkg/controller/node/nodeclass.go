@@ -0,0 +1,115 @@
+package node
+
+import (
+	"encoding/json"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// NodeClassIndex is the name of the node index by nodeclass name
+	NodeClassIndex = "nodeclass"
+)
+
+// NodeClassIndexFunc indexes nodes by the name of their nodeclass
+func NodeClassIndexFunc(obj interface{}) ([]string, error) {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return []string{}, nil
+	}
+	name := node.Annotations[v1alpha1.NodeClassNameAnnotationKey]
+	if name == "" {
+		return []string{}, nil
+	}
+	return []string{name}, nil
+}
+
+// enqueueNodesOfNodeClass enqueues all nodes which use the given nodeclass,
+// directly or via nodeclasses inheriting from it.
+func (c *Controller) enqueueNodesOfNodeClass(obj interface{}) {
+	class, ok := obj.(*v1alpha1.NodeClass)
+	if !ok {
+		return
+	}
+
+	for _, name := range c.dependentNodeClasses(class.Name) {
+		nodes, err := c.nodeIndexer.ByIndex(NodeClassIndex, name)
+		if err != nil {
+			glog.V(0).Infof("Failed to get nodes of nodeclass %s: %v", name, err)
+			continue
+		}
+		for _, n := range nodes {
+			glog.V(6).Infof("Enqueuing node %s because nodeclass %s changed", n.(*v1.Node).Name, class.Name)
+			c.nodeQueue.Add(n.(*v1.Node).Name)
+		}
+	}
+}
+
+// dependentNodeClasses returns the given nodeclass and all nodeclasses which reference it as parent or fragment
+func (c *Controller) dependentNodeClasses(name string) []string {
+	dependents := []string{name}
+	seen := map[string]bool{name: true}
+
+	for i := 0; i < len(dependents); i++ {
+		for _, obj := range c.nodeClassStore.List() {
+			class := obj.(*v1alpha1.NodeClass)
+			if seen[class.Name] {
+				continue
+			}
+
+			var config nodeclass.NodeClassConfig
+			if err := json.Unmarshal(class.Config.Raw, &config); err != nil {
+				continue
+			}
+			references := append([]string{config.Parent}, config.Fragments...)
+			for _, ref := range references {
+				if ref == dependents[i] {
+					seen[class.Name] = true
+					dependents = append(dependents, class.Name)
+					break
+				}
+			}
+		}
+	}
+
+	return dependents
+}
+
+// runningOutdated marks nodes as outdated, whose current nodeclass differs from the snapshot
+// taken when the instance got created or re-provisioned
+func (c *Controller) runningOutdated(node *v1.Node) (*v1.Node, error) {
+	recorded := node.Annotations[nodeClassHashAnnotationKey]
+	if recorded == "" {
+		return nil, nil
+	}
+
+	class, config, err := c.getLiveNodeClass(node)
+	if err != nil {
+		// Without a resolvable nodeclass there is nothing to compare against. The remaining steps must not be blocked by it
+		if isNodeClassNotFound(err) || err == noNodeClassDefinedErr {
+			glog.V(6).Infof("Skipping outdated check of node %s: %v", node.Name, err)
+			return nil, nil
+		}
+		return nil, err
+	}
+	hash, err := nodeClassHash(class, config)
+	if err != nil {
+		return nil, err
+	}
+
+	outdated := hash != recorded
+	if outdated && node.Annotations[outdatedAnnotationKey] == "" {
+		glog.V(4).Infof("Node %s is outdated as nodeclass %s changed", node.Name, class.Name)
+		node.Annotations[outdatedAnnotationKey] = "true"
+		return node, nil
+	}
+	if !outdated && node.Annotations[outdatedAnnotationKey] != "" {
+		delete(node.Annotations, outdatedAnnotationKey)
+		return node, nil
+	}
+	return nil, nil
+}
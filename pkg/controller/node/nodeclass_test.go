@@ -0,0 +1,68 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetReferencedNodeClass(t *testing.T) {
+	cached := &v1alpha1.NodeClass{}
+	cached.Name = "cached"
+	uncached := &v1alpha1.NodeClass{}
+	uncached.Name = "uncached"
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(cached)
+	getUncached := func(name string) (*v1alpha1.NodeClass, error) {
+		if name == uncached.Name {
+			return uncached, nil
+		}
+		return nil, kerrors.NewNotFound(schema.GroupResource{Group: v1alpha1.GroupName, Resource: v1alpha1.NodeClassResourcePlural}, name)
+	}
+
+	tests := []struct {
+		name        string
+		getUncached NodeClassGetter
+		reference   string
+		found       bool
+	}{
+		{
+			name:      "cached",
+			reference: "cached",
+			found:     true,
+		},
+		{
+			name:      "uncached without label selector",
+			reference: "uncached",
+		},
+		{
+			name:        "uncached with label selector",
+			getUncached: getUncached,
+			reference:   "uncached",
+			found:       true,
+		},
+		{
+			name:        "missing",
+			getUncached: getUncached,
+			reference:   "missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Controller{nodeClassStore: store, getUncachedNodeClass: test.getUncached}
+			class, err := c.getReferencedNodeClass(test.reference)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found := class != nil; found != test.found {
+				t.Errorf("expected found %t, got %t", test.found, found)
+			}
+		})
+	}
+}
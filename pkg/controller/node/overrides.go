@@ -0,0 +1,108 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+)
+
+const (
+	dockerMachineFlagsKey = "dockerMachineFlags"
+	overridableKeysKey    = "overridableKeys"
+)
+
+// applyNodeClassOverrides applies the given JSON merge patch to the nodeclass config.
+// Only the keys listed in the OverridableKeys of the config can be overridden.
+func applyNodeClassOverrides(overrides string, config *nodeclass.NodeClassConfig) (*nodeclass.NodeClassConfig, error) {
+	if overrides == "" {
+		return config, nil
+	}
+
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(overrides), &patch); err != nil {
+		return nil, fmt.Errorf("overrides must be a JSON object: %v", err)
+	}
+
+	if err := validateOverrides(patch, config.OverridableKeys); err != nil {
+		return nil, err
+	}
+
+	original, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(original, &doc); err != nil {
+		return nil, err
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return nil, err
+	}
+
+	var result nodeclass.NodeClassConfig
+	if err := json.Unmarshal(merged, &result); err != nil {
+		return nil, fmt.Errorf("could not unmarshal overridden config: %v", err)
+	}
+	return &result, nil
+}
+
+func validateOverrides(patch map[string]interface{}, overridableKeys []string) error {
+	allowed := map[string]bool{}
+	for _, k := range overridableKeys {
+		allowed[k] = true
+	}
+
+	var denied []string
+	for k, v := range patch {
+		if k == overridableKeysKey {
+			denied = append(denied, k)
+			continue
+		}
+		if allowed[k] {
+			continue
+		}
+
+		flags, isMap := v.(map[string]interface{})
+		if k != dockerMachineFlagsKey || !isMap {
+			denied = append(denied, k)
+			continue
+		}
+		for flag := range flags {
+			if !allowed[dockerMachineFlagsKey+"."+flag] {
+				denied = append(denied, dockerMachineFlagsKey+"."+flag)
+			}
+		}
+	}
+
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return fmt.Errorf("keys are not overridable: %v", denied)
+	}
+	return nil
+}
+
+// mergePatch applies a JSON merge patch as specified in RFC 7386
+func mergePatch(doc interface{}, patch interface{}) interface{} {
+	patchMap, isMap := patch.(map[string]interface{})
+	if !isMap {
+		return patch
+	}
+
+	docMap, isMap := doc.(map[string]interface{})
+	if !isMap {
+		docMap = map[string]interface{}{}
+	}
+
+	for k, v := range patchMap {
+		if v == nil {
+			delete(docMap, k)
+			continue
+		}
+		docMap[k] = mergePatch(docMap[k], v)
+	}
+	return docMap
+}
@@ -4,19 +4,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/state"
+	"github.com/golang/glog"
 	"github.com/kube-node/kube-machine/pkg/libmachine"
 	nodehelper "github.com/kube-node/kube-machine/pkg/node"
 	"github.com/kube-node/kube-machine/pkg/options"
 	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
 
 	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	noExecuteTaintKey = "node.k8s.io/not-up"
+
+	failureRetryInterval = 30 * time.Second
 )
 
 func (c *Controller) syncPendingNode(node *v1.Node) (changedN *v1.Node, err error) {
@@ -36,6 +42,11 @@ func (c *Controller) syncPendingNode(node *v1.Node) (changedN *v1.Node, err erro
 		return changedN, err
 	}
 
+	changedN, err = c.pendingReleaseCreationClaim(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
 	changedN, err = c.pendingCreateInstanceDetails(node)
 	if err != nil || changedN != nil {
 		return changedN, err
@@ -81,7 +92,17 @@ func (c *Controller) pendingCreateInstance(node *v1.Node) (*v1.Node, error) {
 		return nil, nil
 	}
 
-	class, config, err := c.getNodeClass(node)
+	// The cache might not yet contain the driver data of an instance we just created
+	current, err := c.client.CoreV1().Nodes().Get(node.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if current.Annotations[driverDataAnnotationKey] != "" {
+		return nil, kerrors.NewConflict(v1.Resource("nodes"), node.Name, errors.New("the instance got already created"))
+	}
+
+	// Without an instance there is nothing a snapshot could describe yet, a corrected nodeclass must be picked up
+	class, config, err := c.getLiveNodeClass(node)
 	if err != nil {
 		return nil, fmt.Errorf("could not get nodeclass %q for node %s: %v", node.Annotations[v1alpha1.NodeClassNameAnnotationKey], node.Name, err)
 	}
@@ -99,30 +120,81 @@ func (c *Controller) pendingCreateInstance(node *v1.Node) (*v1.Node, error) {
 		return nil, fmt.Errorf("failed to create docker machine host for node %q: %v", node.Name, err)
 	}
 
-	opts := options.New(config.DockerMachineFlags)
+	flags, err := options.ApplyResources(config.DockerMachineFlags, class.Resources, c.getResourceContent, resourceDir(node.Name))
+	if err != nil {
+		return c.setFailureReason(node, fmt.Sprintf("invalid nodeclass resources: %v", err)), nil
+	}
+
+	opts := options.New(flags)
 	mcnFlags := mhost.Driver.GetCreateFlags()
-	driverOpts := options.GetDriverOpts(opts, mcnFlags, class.Resources)
+	driverOpts, err := options.GetDriverOpts(opts, mcnFlags)
+	if err != nil {
+		return c.setFailureReason(node, fmt.Sprintf("invalid docker machine flags: %v", err)), nil
+	}
 
-	if url, exists := config.DockerMachineFlags["engine-install-url"]; exists {
+	if url, exists := flags["engine-install-url"]; exists {
 		mhost.HostOptions.EngineOptions.InstallURL = url
 	}
 
-	mhost.Driver.SetConfigFromFlags(driverOpts)
+	err = mhost.Driver.SetConfigFromFlags(driverOpts)
+	if err != nil {
+		return c.setFailureReason(node, fmt.Sprintf("invalid driver configuration: %v", err)), nil
+	}
+
+	// Later phases use the snapshot, so they are not affected by changes or the deletion of the nodeclass.
+	// It only gets referenced by the node once the instance got created.
+	hash, ref, err := c.storeNodeClassSnapshot(node, class, config)
+	if err != nil {
+		return nil, err
+	}
+
+	releaseClaim, err := c.claimCreation(node.Name)
+	if err != nil {
+		return nil, err
+	}
 
 	err = mapi.Create(mhost)
 	if err != nil {
+		c.metrics.Machines.WithLabelValues(machineEventFailed).Inc()
 		mhost.Driver.Remove()
+		releaseClaim()
 		return nil, fmt.Errorf("failed to create node %q on cloud provider: %v. Deleted eventually created node on cloud provider", node.Name, err)
 	}
 
+	c.metrics.Machines.WithLabelValues(machineEventCreated).Inc()
+	c.recordSideEffect(node)
+
 	data, err := json.Marshal(mhost)
 	if err != nil {
 		return nil, err
 	}
 	node.Annotations[driverDataAnnotationKey] = string(data)
+	recordNodeClassSnapshot(node, hash, ref)
+	if overrides := node.Annotations[nodeClassOverridesAnnotationKey]; overrides != "" {
+		node.Annotations[nodeClassAppliedOverridesAnnotationKey] = overrides
+	}
+	delete(node.Annotations, failureReasonAnnotationKey)
 	return node, nil
 }
 
+// pendingReleaseCreationClaim removes the creation claim once the driver data of the created instance got persisted
+func (c *Controller) pendingReleaseCreationClaim(node *v1.Node) (*v1.Node, error) {
+	if node.Annotations[creationClaimAnnotationKey] == "" {
+		return nil, nil
+	}
+	delete(node.Annotations, creationClaimAnnotationKey)
+	return node, nil
+}
+
+// setFailureReason records why the node cannot be created. The creation gets retried after failureRetryInterval,
+// as an unchanged failure reason does not cause a node update which would requeue the node.
+func (c *Controller) setFailureReason(node *v1.Node, reason string) *v1.Node {
+	glog.V(0).Infof("Failed to create node %s: %s", node.Name, reason)
+	node.Annotations[failureReasonAnnotationKey] = reason
+	c.nodeQueue.AddAfter(node.Name, failureRetryInterval)
+	return node
+}
+
 func (c *Controller) pendingCreateInstanceDetails(node *v1.Node) (*v1.Node, error) {
 	if node.Annotations[publicIPAnnotationKey] != "" {
 		return nil, nil
@@ -131,22 +203,22 @@ func (c *Controller) pendingCreateInstanceDetails(node *v1.Node) (*v1.Node, erro
 	mapi := libmachine.New()
 	defer mapi.Close()
 
-	h, err := mapi.Load(node)
+	h, err := c.LoadHost(mapi, node)
 	if err != nil {
 		return nil, err
 	}
 
-	ip, err := h.Driver.GetIP()
+	_, config, err := c.getNodeClass(node)
 	if err != nil {
-		return nil, errors.New("could not get public ip")
+		return nil, fmt.Errorf("could not get nodeclass %q for node %s: %v", node.Annotations[v1alpha1.NodeClassNameAnnotationKey], node.Name, err)
 	}
-	node.Annotations[publicIPAnnotationKey] = ip
 
-	hostname, err := h.Driver.GetSSHHostname()
-	if err != nil {
-		return nil, errors.New("could not get hostname")
+	if _, err := setInstanceDetails(node, h, config); err != nil {
+		return nil, err
+	}
+	if err := c.syncNodeAddresses(node); err != nil {
+		return nil, err
 	}
-	node.Annotations[hostnameAnnotationKey] = hostname
 
 	return node, nil
 
@@ -156,7 +228,7 @@ func (c *Controller) pendingWaitUntilInstanceIsRunning(node *v1.Node) (*v1.Node,
 	mapi := libmachine.New()
 	defer mapi.Close()
 
-	h, err := mapi.Load(node)
+	h, err := c.LoadHost(mapi, node)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,103 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+// persistBackoff limits the immediate retries to persist the result of a side effect.
+// Afterwards the patch is kept and written before the node gets synced again.
+var persistBackoff = wait.Backoff{
+	Duration: 100 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    6,
+}
+
+// recordSideEffect marks that the current sync of the node did something which cannot be repeated safely,
+// e.g. created or stopped the instance. The changes of the sync then get persisted regardless of concurrent changes.
+func (c *Controller) recordSideEffect(node *corev1.Node) {
+	c.persistLock.Lock()
+	defer c.persistLock.Unlock()
+	c.sideEffects[node.Name] = true
+}
+
+// takeSideEffect returns whether the current sync of the node recorded a side effect and resets it
+func (c *Controller) takeSideEffect(name string) bool {
+	c.persistLock.Lock()
+	defer c.persistLock.Unlock()
+	sideEffect := c.sideEffects[name]
+	delete(c.sideEffects, name)
+	return sideEffect
+}
+
+// writeNode writes the changes of a sync. Changes which only reconcile the node are based on the cached node and fail
+// if it changed in the meantime. Results of side effects must not get lost, they are merged into the current node.
+func (c *Controller) writeNode(originalData []byte, node *corev1.Node, sideEffect bool) error {
+	if sideEffect {
+		return c.persistNode(originalData, node)
+	}
+	return c.updateNode(originalData, node)
+}
+
+// persistNode patches the node without a resourceVersion precondition, so the changes get merged into the current node
+// by the API server. The patch gets retried until it succeeded, if necessary across syncs.
+func (c *Controller) persistNode(originalData []byte, node *corev1.Node) error {
+	modifiedData, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	patch, err := strategicpatch.CreateTwoWayMergePatch(originalData, modifiedData, corev1.Node{})
+	if err != nil {
+		return err
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	return c.writePersistPatch(node.Name, patch)
+}
+
+func (c *Controller) writePersistPatch(name string, patch []byte) error {
+	err := retry.OnError(persistBackoff, func(err error) bool { return !kerrors.IsNotFound(err) }, func() error {
+		_, err := c.client.CoreV1().Nodes().Patch(name, types.StrategicMergePatchType, patch)
+		return err
+	})
+
+	c.persistLock.Lock()
+	defer c.persistLock.Unlock()
+	if kerrors.IsNotFound(err) {
+		glog.V(0).Infof("Node %s got deleted before the result of a side effect got persisted: %s", name, patch)
+		delete(c.unpersisted, name)
+		return nil
+	}
+	if err != nil {
+		c.unpersisted[name] = patch
+		return fmt.Errorf("failed to persist node %s, retrying before it gets synced again: %v", name, err)
+	}
+	delete(c.unpersisted, name)
+	return nil
+}
+
+// flushUnpersisted writes the patch which failed to persist during an earlier sync of the node.
+// Returns true if there was such a patch, the node must not be synced based on the cache then.
+func (c *Controller) flushUnpersisted(name string) (bool, error) {
+	c.persistLock.Lock()
+	patch, exists := c.unpersisted[name]
+	c.persistLock.Unlock()
+
+	if !exists {
+		return false, nil
+	}
+	return true, c.writePersistPatch(name, patch)
+}
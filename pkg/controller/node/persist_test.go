@@ -0,0 +1,203 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newVersionedClient returns a fake clientset which versions nodes like the API server. Every write increments the
+// resourceVersion, updates & patches with an outdated resourceVersion fail with a conflict.
+func newVersionedClient(nodes ...*v1.Node) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	gvr := v1.SchemeGroupVersion.WithResource("nodes")
+	for _, node := range nodes {
+		node = node.DeepCopy()
+		node.ResourceVersion = "1"
+		client.Tracker().Add(node)
+	}
+
+	write := func(current, updated *v1.Node) (bool, runtime.Object, error) {
+		version, err := strconv.Atoi(current.ResourceVersion)
+		if err != nil {
+			return true, nil, err
+		}
+		updated.ResourceVersion = strconv.Itoa(version + 1)
+		return true, updated, client.Tracker().Update(gvr, updated, "")
+	}
+	get := func(name string) (*v1.Node, error) {
+		obj, err := client.Tracker().Get(gvr, "", name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*v1.Node), nil
+	}
+	conflict := func(name string) error {
+		return kerrors.NewConflict(v1.Resource("nodes"), name, errors.New("the object has been modified"))
+	}
+
+	client.PrependReactor("update", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		node := action.(k8stesting.UpdateAction).GetObject().(*v1.Node).DeepCopy()
+		current, err := get(node.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if node.ResourceVersion != current.ResourceVersion {
+			return true, nil, conflict(node.Name)
+		}
+		return write(current, node)
+	})
+	client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(k8stesting.PatchAction)
+		if patchAction.GetPatchType() != types.StrategicMergePatchType {
+			return false, nil, nil
+		}
+		current, err := get(patchAction.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		patch := map[string]interface{}{}
+		if err := json.Unmarshal(patchAction.GetPatch(), &patch); err != nil {
+			return true, nil, err
+		}
+		if metadata, ok := patch["metadata"].(map[string]interface{}); ok {
+			if rv, ok := metadata["resourceVersion"]; ok && rv != current.ResourceVersion {
+				return true, nil, conflict(current.Name)
+			}
+		}
+
+		currentData, err := json.Marshal(current)
+		if err != nil {
+			return true, nil, err
+		}
+		data, err := strategicpatch.StrategicMergePatch(currentData, patchAction.GetPatch(), v1.Node{})
+		if err != nil {
+			return true, nil, err
+		}
+		updated := &v1.Node{}
+		if err := json.Unmarshal(data, updated); err != nil {
+			return true, nil, err
+		}
+		return write(current, updated)
+	})
+	return client
+}
+
+func newPersistController(client *fake.Clientset) *Controller {
+	return &Controller{
+		client:      client,
+		persistLock: &sync.Mutex{},
+		sideEffects: map[string]bool{},
+		unpersisted: map[string][]byte{},
+	}
+}
+
+func TestWriteNode(t *testing.T) {
+	defer func(backoff wait.Backoff) { persistBackoff = backoff }(persistBackoff)
+	persistBackoff.Duration = time.Millisecond
+
+	tests := []struct {
+		name       string
+		sideEffect bool
+		failing    bool
+		expectErr  bool
+	}{
+		{
+			name:      "reconcile writes fail on concurrent changes",
+			expectErr: true,
+		},
+		{
+			name:       "results of side effects get merged into the current node",
+			sideEffect: true,
+		},
+		{
+			name:       "results of side effects get persisted before the next sync",
+			sideEffect: true,
+			failing:    true,
+			expectErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newVersionedClient(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node1",
+				Annotations: map[string]string{phaseAnnotationKey: phasePending},
+			}})
+			c := newPersistController(client)
+
+			cached, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			originalData, err := json.Marshal(cached)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Another writer changes the node after it got cached
+			concurrent := cached.DeepCopy()
+			concurrent.Annotations[creationClaimAnnotationKey] = "replica-1"
+			if _, err := client.CoreV1().Nodes().Update(concurrent); err != nil {
+				t.Fatal(err)
+			}
+
+			failing := test.failing
+			client.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if failing {
+					return true, nil, kerrors.NewServerTimeout(v1.Resource("nodes"), "patch", 1)
+				}
+				return false, nil, nil
+			})
+
+			node := cached.DeepCopy()
+			node.Annotations[driverDataAnnotationKey] = `{"Driver":{}}`
+			err = c.writeNode(originalData, node, test.sideEffect)
+			if test.expectErr != (err != nil) {
+				t.Fatalf("expected error %t, got %v", test.expectErr, err)
+			}
+			if !test.sideEffect {
+				if !kerrors.IsConflict(err) {
+					t.Errorf("expected a conflict, got %v", err)
+				}
+				return
+			}
+
+			if test.failing {
+				failing = false
+				flushed, err := c.flushUnpersisted("node1")
+				if err != nil || !flushed {
+					t.Fatalf("expected the unpersisted result to be flushed, got %t: %v", flushed, err)
+				}
+				if flushed, _ := c.flushUnpersisted("node1"); flushed {
+					t.Errorf("expected the result to be flushed only once")
+				}
+			}
+
+			current, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Annotations[driverDataAnnotationKey] == "" {
+				t.Errorf("expected the driver data to be persisted")
+			}
+			if current.Annotations[creationClaimAnnotationKey] != "replica-1" {
+				t.Errorf("expected the concurrent change to be kept")
+			}
+		})
+	}
+}
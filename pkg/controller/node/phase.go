@@ -0,0 +1,82 @@
+package node
+
+import (
+	"time"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+)
+
+// phaseSince returns when the node entered its current phase. Falls back to the creation of the node.
+func phaseSince(node *v1.Node) time.Time {
+	if since, err := time.Parse(time.RFC3339, node.Annotations[phaseSinceAnnotationKey]); err == nil {
+		return since
+	}
+	return node.CreationTimestamp.Time
+}
+
+// recordPhase sets the time the node entered its phase, in case it changed or was not recorded yet
+func recordPhase(node *v1.Node, previousPhase string) {
+	if node.Annotations[phaseAnnotationKey] != previousPhase || node.Annotations[phaseSinceAnnotationKey] == "" {
+		node.Annotations[phaseSinceAnnotationKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+}
+
+// observePhaseTransition records the duration of the left phase and the time to ready once a node is running for the first time
+func (c *Controller) observePhaseTransition(node *v1.Node, from string, since time.Time) {
+	to := node.Annotations[phaseAnnotationKey]
+	if to == from {
+		return
+	}
+
+	provider, class := c.nodeMetricLabels(node)
+	switch from {
+	case phasePending, phaseProvisioning, phaseLaunching:
+		c.metrics.PhaseSeconds.WithLabelValues(from, provider, class).Observe(time.Since(since).Seconds())
+	}
+	if from == phaseLaunching && to == phaseRunning && node.Annotations[reprovisionedAnnotationKey] == "" {
+		c.metrics.TimeToReadySeconds.WithLabelValues(provider, class).Observe(time.Since(node.CreationTimestamp.Time).Seconds())
+	}
+}
+
+func (c *Controller) nodeMetricLabels(node *v1.Node) (provider, class string) {
+	class = node.Annotations[v1alpha1.NodeClassNameAnnotationKey]
+	if _, config, err := c.getNodeClass(node); err == nil {
+		provider = config.Provider
+	}
+	return provider, class
+}
+
+// updateNodeMetrics counts the nodes of this replica per phase and the time the oldest node is in each non-running phase
+func (c *Controller) updateNodeMetrics() {
+	nlist := c.nodeIndexer.List()
+	c.metrics.Nodes.Set(float64(len(nlist)))
+
+	counts := map[string]int{}
+	oldest := map[string]time.Duration{}
+	for _, obj := range nlist {
+		node := obj.(*v1.Node)
+		phase := node.Annotations[phaseAnnotationKey]
+		if phase == "" || !c.ownsNode(node.Name) {
+			continue
+		}
+
+		since := phaseSince(node)
+		if node.DeletionTimestamp != nil {
+			phase = phaseDeleting
+			since = node.DeletionTimestamp.Time
+		}
+		counts[phase]++
+		if age := time.Since(since); phase != phaseRunning && age > oldest[phase] {
+			oldest[phase] = age
+		}
+	}
+
+	for _, phase := range []string{phasePending, phaseProvisioning, phaseLaunching, phaseRunning, phaseDeleting} {
+		c.metrics.PhaseNodes.WithLabelValues(phase).Set(float64(counts[phase]))
+		if phase != phaseRunning {
+			c.metrics.OldestNodeSeconds.WithLabelValues(phase).Set(oldest[phase].Seconds())
+		}
+	}
+}
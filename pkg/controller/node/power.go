@@ -0,0 +1,170 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/state"
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	powerStateOn      = "on"
+	powerStateOff     = "off"
+	powerStateRestart = "restart"
+)
+
+// runningPowerState reconciles the desired power state of the node, which got specified via annotation.
+// Before an instance gets stopped or restarted, the node gets cordoned & drained.
+func (c *Controller) runningPowerState(node *v1.Node) (*v1.Node, error) {
+	desired := desiredPowerState(node)
+	if desired == "" {
+		return nil, nil
+	}
+	if desired != powerStateOn && desired != powerStateOff && desired != powerStateRestart {
+		return nil, fmt.Errorf("invalid power state %q for node %s. Must be one of %s, %s, %s", desired, node.Name, powerStateOn, powerStateOff, powerStateRestart)
+	}
+
+	mapi := libmachine.New()
+	defer mapi.Close()
+
+	h, err := c.LoadHost(mapi, node)
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := getPowerState(h)
+	if err != nil {
+		return nil, err
+	}
+
+	restarted := false
+	switch desired {
+	case powerStateOff:
+		if actual == powerStateOff {
+			break
+		}
+		if changedN, drained, err := c.cordonAndDrain(node); err != nil || !drained {
+			return changedN, err
+		}
+
+		glog.V(2).Infof("Stopping instance of node %s", node.Name)
+		if err := h.Driver.Stop(); err != nil {
+			return nil, fmt.Errorf("failed to stop instance of node %s: %v", node.Name, err)
+		}
+		c.recordSideEffect(node)
+	case powerStateOn:
+		if actual == powerStateOff {
+			glog.V(2).Infof("Starting instance of node %s", node.Name)
+			if err := h.Driver.Start(); err != nil {
+				return nil, fmt.Errorf("failed to start instance of node %s: %v", node.Name, err)
+			}
+			c.recordSideEffect(node)
+		}
+		if actual == powerStateOn && node.Annotations[powerCordonAnnotationKey] != "" {
+			node.Spec.Unschedulable = false
+			delete(node.Annotations, powerCordonAnnotationKey)
+		}
+	case powerStateRestart:
+		if changedN, drained, err := c.cordonAndDrain(node); err != nil || !drained {
+			return changedN, err
+		}
+
+		// The restart request gets consumed before the restart, so a failed write afterwards cannot restart the instance twice
+		if err := c.swapPowerState(node, powerStateRestart, powerStateOn); err != nil {
+			return nil, fmt.Errorf("failed to consume restart request of node %s: %v", node.Name, err)
+		}
+
+		glog.V(2).Infof("Restarting instance of node %s", node.Name)
+		if err := h.Driver.Restart(); err != nil {
+			// The restart gets retried with the next sync
+			if swapErr := c.swapPowerState(node, powerStateOn, powerStateRestart); swapErr != nil {
+				glog.V(0).Infof("Failed to restore restart request of node %s: %v", node.Name, swapErr)
+			}
+			return nil, fmt.Errorf("failed to restart instance of node %s: %v", node.Name, err)
+		}
+		c.recordSideEffect(node)
+		restarted = true
+	}
+
+	actual, err = getPowerState(h)
+	if err != nil {
+		return nil, err
+	}
+	if node.Annotations[powerStateActualAnnotationKey] != actual || restarted {
+		node.Annotations[powerStateActualAnnotationKey] = actual
+		return node, nil
+	}
+
+	return nil, nil
+}
+
+// swapPowerState changes the desired power state of the node. It fails if the power state got changed meanwhile.
+func (c *Controller) swapPowerState(node *v1.Node, from, to string) error {
+	path := "/metadata/annotations/" + strings.Replace(strings.Replace(powerStateAnnotationKey, "~", "~0", -1), "/", "~1", -1)
+	patch, err := json.Marshal([]map[string]string{
+		{"op": "test", "path": path, "value": from},
+		{"op": "replace", "path": path, "value": to},
+	})
+	if err != nil {
+		return err
+	}
+
+	updated, err := c.client.CoreV1().Nodes().Patch(node.Name, types.JSONPatchType, patch)
+	if err != nil {
+		return err
+	}
+	node.Annotations[powerStateAnnotationKey] = to
+	node.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// desiredPowerState returns the power state specified via annotation. Hibernated nodes are always off.
+func desiredPowerState(node *v1.Node) string {
+	if node.Annotations[hibernatedAnnotationKey] != "" {
+		return powerStateOff
+	}
+	return node.Annotations[powerStateAnnotationKey]
+}
+
+// cordonAndDrain cordons the node, the drain starts with the next sync. Returns true once no pods are left to evict.
+// The node gets uncordoned again once it is powered on.
+func (c *Controller) cordonAndDrain(node *v1.Node) (*v1.Node, bool, error) {
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		node.Annotations[powerCordonAnnotationKey] = "true"
+		return node, false, nil
+	}
+
+	drained, err := c.drainNode(node)
+	if err != nil {
+		return nil, false, err
+	}
+	if !drained {
+		glog.V(4).Infof("Waiting until node %s is drained before changing its power state", node.Name)
+		return nil, false, nil
+	}
+	return nil, true, nil
+}
+
+func getPowerState(h *host.Host) (string, error) {
+	s, err := h.Driver.GetState()
+	if err != nil {
+		return "", fmt.Errorf("failed getting instance state: %v", err)
+	}
+
+	switch s {
+	case state.Running:
+		return powerStateOn, nil
+	case state.Stopped:
+		return powerStateOff, nil
+	default:
+		return strings.ToLower(s.String()), nil
+	}
+}
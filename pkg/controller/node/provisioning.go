@@ -3,13 +3,21 @@ package node
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/docker/machine/libmachine/host"
+	"github.com/golang/glog"
 	"github.com/kube-node/kube-machine/pkg/libmachine"
 	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+// systemUUIDTimeout limits the time to read the system uuid via SSH
+const systemUUIDTimeout = 30 * time.Second
+
 func (c *Controller) syncProvisioningNode(node *v1.Node) (changedN *v1.Node, err error) {
 	changedN, err = c.provisionInstance(node)
 	if err != nil || changedN != nil {
@@ -23,7 +31,7 @@ func (c *Controller) provisionInstance(node *v1.Node) (*v1.Node, error) {
 	mapi := libmachine.New()
 	defer mapi.Close()
 
-	h, err := mapi.Load(node)
+	h, err := c.LoadHost(mapi, node)
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +43,20 @@ func (c *Controller) provisionInstance(node *v1.Node) (*v1.Node, error) {
 
 	err = mapi.Provision(h, config)
 	if err != nil {
-		return nil, fmt.Errorf("could not provision: %v", err)
+		err = fmt.Errorf("could not provision: %v", err)
+		c.metrics.Machines.WithLabelValues(machineEventFailed).Inc()
+		if isReprovisioning(node) {
+			c.recordReprovisionFailure(node, err)
+		}
+		return nil, err
+	}
+	c.recordSideEffect(node)
+
+	// The system uuid is used to find the node registered by the kubelet of this machine
+	if uuid, err := getSystemUUID(mapi, h); err != nil {
+		glog.V(2).Infof("Failed to get system uuid of node %s: %v", node.Name, err)
+	} else {
+		node.Annotations[systemUUIDAnnotationKey] = uuid
 	}
 
 	data, err := json.Marshal(h)
@@ -45,6 +66,45 @@ func (c *Controller) provisionInstance(node *v1.Node) (*v1.Node, error) {
 
 	node.Annotations[driverDataAnnotationKey] = string(data)
 	node.Annotations[phaseAnnotationKey] = phaseLaunching
+	// The join of the kubelet gets checked again
+	delete(node.Annotations, joinedAnnotationKey)
+	delete(node.Annotations, joinedBootIDAnnotationKey)
+	if isReprovisioning(node) {
+		finishReprovision(node)
+	}
 
 	return node, nil
 }
+
+// recordReprovisionFailure stores the reason of a failed re-provisioning on the node.
+// The node stays in the provisioning phase, so the provisioning will be retried.
+func (c *Controller) recordReprovisionFailure(node *v1.Node, provisionErr error) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				reprovisionResultAnnotationKey: fmt.Sprintf("failed: %v", provisionErr),
+			},
+		},
+	})
+	if err != nil {
+		glog.V(0).Infof("Failed to marshal re-provisioning result for node %s: %v", node.Name, err)
+		return
+	}
+
+	_, err = c.client.CoreV1().Nodes().Patch(node.Name, types.MergePatchType, patch)
+	if err != nil {
+		glog.V(0).Infof("Failed to record re-provisioning result for node %s: %v", node.Name, err)
+	}
+}
+
+// getSystemUUID reads the system uuid of the machine, which gets reported by the kubelet as nodeInfo.systemUUID
+func getSystemUUID(mapi *libmachine.Client, h *host.Host) (string, error) {
+	stdout, stderr, exitCode, err := mapi.RunSSHCommand(h, "sudo cat /sys/class/dmi/id/product_uuid", systemUUIDTimeout)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("exit code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+	return strings.TrimSpace(stdout), nil
+}
@@ -0,0 +1,83 @@
+package node
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+	"github.com/kube-node/kube-machine/pkg/options"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getResourceContent returns the content of the key of the Secret or ConfigMap the reference points to.
+// The key is specified via FieldPath and can be omitted if the object only contains a single key.
+// Only objects of the allowed resource namespaces can be read.
+func (c *Controller) getResourceContent(ref *v1.ObjectReference) ([]byte, error) {
+	if err := options.ValidateReferenceNamespace(ref, c.resourceNamespaces); err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{}
+
+	switch ref.Kind {
+	case "Secret":
+		secret, err := c.client.CoreV1().Secrets(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data = secret.Data
+	case "ConfigMap":
+		cm, err := c.client.CoreV1().ConfigMaps(ref.Namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported kind %q. Must be Secret or ConfigMap", ref.Kind)
+	}
+
+	key := ref.FieldPath
+	if key == "" {
+		if len(data) != 1 {
+			return nil, fmt.Errorf("%s %s/%s contains %d keys, the key must be specified via fieldPath", ref.Kind, ref.Namespace, ref.Name, len(data))
+		}
+		for k := range data {
+			key = k
+		}
+	}
+
+	content, exists := data[key]
+	if !exists {
+		return nil, fmt.Errorf("%s %s/%s has no key %q", ref.Kind, ref.Namespace, ref.Name, key)
+	}
+	return content, nil
+}
+
+// resourceDir returns the directory the file resources of the node get written into
+func resourceDir(nodeName string) string {
+	return filepath.Join("machines", nodeName, "resources")
+}
+
+// LoadHost loads the docker machine host of the node. The file resources of its nodeclass get written before,
+// the driver data references them by path and they are gone after a restart of kube-machine.
+func (c *Controller) LoadHost(mapi *libmachine.Client, node *v1.Node) (*host.Host, error) {
+	class, _, err := c.getNodeClass(node)
+	switch {
+	case err == noNodeClassDefinedErr || isNodeClassNotFound(err):
+		// Drivers which do not need any resources still work, e.g. to delete the instance of a deleted nodeclass
+		glog.V(2).Infof("No nodeclass to restore the resources of node %s from: %v", node.Name, err)
+	case err != nil:
+		return nil, fmt.Errorf("failed to get nodeclass to restore the resources of node %s: %v", node.Name, err)
+	default:
+		if err := options.WriteResourceFiles(class.Resources, c.getResourceContent, resourceDir(node.Name)); err != nil {
+			return nil, fmt.Errorf("failed to restore the resources of node %s: %v", node.Name, err)
+		}
+	}
+	return mapi.Load(node)
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const reprovisionResultSucceeded = "succeeded"
+
+func (c *Controller) syncRunningNode(node *v1.Node) (changedN *v1.Node, err error) {
+	changedN, err = c.runningNodeClassLabel(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
+	changedN, err = c.runningInstanceDetails(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
+	changedN, err = c.runningOutdated(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
+	changedN, err = c.runningReprovision(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
+	changedN, err = c.runningPowerState(node)
+	if err != nil || changedN != nil {
+		return changedN, err
+	}
+
+	return nil, nil
+}
+
+// runningNodeClassLabel mirrors the node class annotation into a label, so all nodes of a class
+// can be selected with a label selector. E.g. for bulk re-provisioning.
+func (c *Controller) runningNodeClassLabel(node *v1.Node) (*v1.Node, error) {
+	name := node.Annotations[v1alpha1.NodeClassNameAnnotationKey]
+	if name == "" || len(validation.IsValidLabelValue(name)) > 0 {
+		return nil, nil
+	}
+	if node.Labels[v1alpha1.NodeClassNameAnnotationKey] == name {
+		return nil, nil
+	}
+
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	node.Labels[v1alpha1.NodeClassNameAnnotationKey] = name
+	return node, nil
+}
+
+// runningInstanceDetails periodically refreshes the instance details, as they might change during the lifetime of an instance
+func (c *Controller) runningInstanceDetails(node *v1.Node) (*v1.Node, error) {
+	if !c.needsInstanceDetailsRefresh(node) {
+		return nil, nil
+	}
+
+	mapi := libmachine.New()
+	defer mapi.Close()
+
+	h, err := c.LoadHost(mapi, node)
+	if err != nil {
+		return nil, err
+	}
+
+	_, config, err := c.getNodeClass(node)
+	if err != nil {
+		return nil, fmt.Errorf("could not get nodeclass %q for node %s: %v", node.Annotations[v1alpha1.NodeClassNameAnnotationKey], node.Name, err)
+	}
+
+	changed, err := setInstanceDetails(node, h, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.syncNodeAddresses(node); err != nil {
+		return nil, err
+	}
+
+	// A changed node gets refreshed again after it got updated, so a failed update does not delay the refresh
+	if changed {
+		return node, nil
+	}
+	c.markInstanceDetailsRefreshed(node)
+	return nil, nil
+}
+
+// runningReprovision moves the node back to the provisioning phase if a new re-provisioning request
+// got set via annotation. The node gets cordoned until the provisioning is done.
+func (c *Controller) runningReprovision(node *v1.Node) (*v1.Node, error) {
+	nonce := node.Annotations[reprovisionAnnotationKey]
+	if nonce == "" || nonce == node.Annotations[reprovisionedAnnotationKey] {
+		return nil, nil
+	}
+
+	glog.V(4).Infof("Re-provisioning of node %s requested (%s)", node.Name, nonce)
+
+	// Re-provisioning applies the current nodeclass
+	class, config, err := c.getLiveNodeClass(node)
+	if err != nil {
+		return nil, fmt.Errorf("could not get nodeclass %q for node %s: %v", node.Annotations[v1alpha1.NodeClassNameAnnotationKey], node.Name, err)
+	}
+	if err := c.createNodeClassSnapshot(node, class, config); err != nil {
+		return nil, err
+	}
+
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		node.Annotations[reprovisionCordonAnnotationKey] = "true"
+	}
+	node.Annotations[reprovisionedAnnotationKey] = nonce
+	// The marker stays until the provisioning succeeded, the result only gets written once a run finished
+	node.Annotations[reprovisioningAnnotationKey] = nonce
+	delete(node.Annotations, reprovisionResultAnnotationKey)
+	node.Annotations[phaseAnnotationKey] = phaseProvisioning
+	return node, nil
+}
+
+func isReprovisioning(node *v1.Node) bool {
+	return node.Annotations[reprovisioningAnnotationKey] != ""
+}
+
+// finishReprovision records the result of a re-provisioning and uncordons the node if it got cordoned by us.
+func finishReprovision(node *v1.Node) {
+	node.Annotations[reprovisionResultAnnotationKey] = reprovisionResultSucceeded
+	delete(node.Annotations, reprovisioningAnnotationKey)
+	if node.Annotations[reprovisionCordonAnnotationKey] != "" {
+		node.Spec.Unschedulable = false
+		delete(node.Annotations, reprovisionCordonAnnotationKey)
+	}
+}
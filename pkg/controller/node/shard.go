@@ -0,0 +1,74 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ownsNode returns true if the node is assigned to this replica. Without sharding all nodes are owned.
+func (c *Controller) ownsNode(name string) bool {
+	return c.shard == nil || c.shard.Owns(name)
+}
+
+// enqueueAllNodes enqueues every node, so nodes which got assigned to this replica after a rebalancing get picked up
+func (c *Controller) enqueueAllNodes() {
+	nlist := c.nodeIndexer.List()
+	glog.V(4).Infof("Shard members changed, enqueuing all %d nodes", len(nlist))
+	for _, obj := range nlist {
+		c.nodeQueue.Add(obj.(*v1.Node).Name)
+	}
+}
+
+// claimCreation fences the creation of the instance of the node against other replicas, which might consider themselves
+// the owner while the members change. The claim gets written with the resourceVersion of the node as precondition,
+// so only one replica can succeed. Claims of replicas which are no members anymore get taken over.
+// The returned function releases the claim, it must be called if the creation failed. After a successful creation the claim
+// gets removed by the next sync, once the driver data got persisted. Without sharding nothing gets claimed.
+func (c *Controller) claimCreation(name string) (func(), error) {
+	if c.shard == nil {
+		return func() {}, nil
+	}
+	identity := c.shard.Identity()
+
+	node, err := c.client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if node.Annotations[driverDataAnnotationKey] != "" {
+		return nil, kerrors.NewConflict(v1.Resource("nodes"), name, errors.New("the instance got already created"))
+	}
+	claimant := node.Annotations[creationClaimAnnotationKey]
+	if claimant != "" && claimant != identity {
+		if c.shard.HasMember(claimant) {
+			return nil, kerrors.NewConflict(v1.Resource("nodes"), name, fmt.Errorf("the creation of the instance is claimed by %s", claimant))
+		}
+		glog.V(2).Infof("Taking over the creation claim of node %s from %s, which is no member anymore", name, claimant)
+	}
+
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[creationClaimAnnotationKey] = identity
+	// Update fails with a conflict if the node changed since we got it, e.g. because another replica claimed it
+	if _, err := c.client.CoreV1().Nodes().Update(node); err != nil {
+		return nil, fmt.Errorf("failed to claim the creation of node %s: %v", name, err)
+	}
+
+	release := func() {
+		node, err := c.client.CoreV1().Nodes().Get(name, metav1.GetOptions{})
+		if err != nil || node.Annotations[creationClaimAnnotationKey] != identity {
+			return
+		}
+		delete(node.Annotations, creationClaimAnnotationKey)
+		if _, err := c.client.CoreV1().Nodes().Update(node); err != nil {
+			glog.V(2).Infof("Failed to release the creation claim of node %s: %v", name, err)
+		}
+	}
+	return release, nil
+}
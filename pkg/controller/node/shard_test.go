@@ -0,0 +1,69 @@
+package node
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kube-node/kube-machine/pkg/shard"
+
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClaimCreation(t *testing.T) {
+	client := newVersionedClient(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node1",
+		Annotations: map[string]string{phaseAnnotationKey: phasePending},
+	}})
+	c := newPersistController(client)
+	c.shard = shard.New(client, "kube-system", "kube-machine", "replica-1", time.Minute)
+
+	// The sync is based on the cached node, the claim changes its resourceVersion
+	node, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalData, err := json.Marshal(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.claimCreation(node.Name); err != nil {
+		t.Fatalf("failed to claim the creation: %v", err)
+	}
+
+	// The instance got created
+	node.Annotations[driverDataAnnotationKey] = `{"Driver":{}}`
+	c.recordSideEffect(node)
+	if err := c.writeNode(originalData, node, c.takeSideEffect(node.Name)); err != nil {
+		t.Fatalf("failed to persist the created instance: %v", err)
+	}
+
+	current, err := client.CoreV1().Nodes().Get("node1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Annotations[driverDataAnnotationKey] == "" {
+		t.Fatalf("expected the driver data to be persisted")
+	}
+	if current.Annotations[creationClaimAnnotationKey] != "replica-1" {
+		t.Errorf("expected the claim to be kept until the next sync, got %q", current.Annotations[creationClaimAnnotationKey])
+	}
+
+	// Other replicas must not create a second instance
+	other := newPersistController(client)
+	other.shard = shard.New(client, "kube-system", "kube-machine", "replica-2", time.Minute)
+	if _, err := other.claimCreation(node.Name); !kerrors.IsConflict(err) {
+		t.Errorf("expected a conflict for another replica, got %v", err)
+	}
+
+	released, err := c.pendingReleaseCreationClaim(current.DeepCopy())
+	if err != nil || released == nil {
+		t.Fatalf("expected the claim to be released, got %v", err)
+	}
+	if _, exists := released.Annotations[creationClaimAnnotationKey]; exists {
+		t.Errorf("expected the claim annotation to be removed")
+	}
+}
@@ -0,0 +1,176 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	snapshotNamePrefix = "nodeclass-snapshot-"
+	snapshotDataKey    = "snapshot"
+	snapshotLabelKey   = "node.k8s.io/nodeclass-snapshot"
+
+	// snapshotRetryInterval is the time after which a snapshot which failed to load gets fetched again
+	snapshotRetryInterval = time.Minute
+)
+
+// createNodeClassSnapshot stores the effective nodeclass and records the hash & the reference on the node.
+func (c *Controller) createNodeClassSnapshot(node *v1.Node, class *v1alpha1.NodeClass, config *nodeclass.NodeClassConfig) error {
+	hash, ref, err := c.storeNodeClassSnapshot(node, class, config)
+	if err != nil {
+		return err
+	}
+	recordNodeClassSnapshot(node, hash, ref)
+	return nil
+}
+
+// storeNodeClassSnapshot stores the effective nodeclass in a Secret named after the hash of its content
+// and returns the hash & the reference. A Secret is used as the docker-machine flags usually contain credentials. Snapshots are never modified, only the owners get extended.
+// They get garbage collected after all nodes using them got deleted.
+func (c *Controller) storeNodeClassSnapshot(node *v1.Node, class *v1alpha1.NodeClass, config *nodeclass.NodeClassConfig) (string, string, error) {
+	snapshot := nodeclass.NewSnapshot(class, config)
+	hash, err := snapshot.Hash()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to hash nodeclass snapshot: %v", err)
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal nodeclass snapshot: %v", err)
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            snapshotNamePrefix + hash[:20],
+			Namespace:       c.stateNamespace,
+			Labels:          map[string]string{snapshotLabelKey: "true"},
+			OwnerReferences: []metav1.OwnerReference{nodeOwnerReference(node)},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			snapshotDataKey: data,
+		},
+	}
+	ref := secret.Namespace + "/" + secret.Name
+	_, err = c.client.CoreV1().Secrets(secret.Namespace).Create(secret)
+	if kerrors.IsAlreadyExists(err) {
+		return hash, ref, c.addSnapshotOwnerReference(ref, nodeOwnerReference(node))
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create nodeclass snapshot %s: %v", ref, err)
+	}
+	return hash, ref, nil
+}
+
+// recordNodeClassSnapshot makes the node use the given snapshot
+func recordNodeClassSnapshot(node *v1.Node, hash, ref string) {
+	node.Annotations[nodeClassHashAnnotationKey] = hash
+	node.Annotations[nodeClassSnapshotAnnotationKey] = ref
+	delete(node.Annotations, outdatedAnnotationKey)
+}
+
+// loadNodeClassSnapshot returns the snapshot the node references.
+// Failures are cached for snapshotRetryInterval, so callers iterating over all nodes do not cause a request per node and call.
+func (c *Controller) loadNodeClassSnapshot(node *v1.Node) (*nodeclass.Snapshot, error) {
+	ref := node.Annotations[nodeClassSnapshotAnnotationKey]
+
+	c.snapshotLock.Lock()
+	data, cached := c.snapshots[ref]
+	failedAt, failed := c.snapshotFailures[ref]
+	c.snapshotLock.Unlock()
+
+	if !cached {
+		if failed && time.Since(failedAt) < snapshotRetryInterval {
+			return nil, fmt.Errorf("nodeclass snapshot %s failed to load at %s, retrying after %s", ref, failedAt.Format(time.RFC3339), snapshotRetryInterval)
+		}
+
+		namespace, name, err := splitSnapshotReference(ref)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := c.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			c.snapshotLock.Lock()
+			c.snapshotFailures[ref] = time.Now()
+			c.snapshotLock.Unlock()
+			return nil, fmt.Errorf("failed to get nodeclass snapshot %s: %v", ref, err)
+		}
+		data = string(secret.Data[snapshotDataKey])
+
+		// Snapshots are immutable, therefore we can cache them forever
+		c.snapshotLock.Lock()
+		c.snapshots[ref] = data
+		delete(c.snapshotFailures, ref)
+		c.snapshotLock.Unlock()
+	}
+
+	snapshot := &nodeclass.Snapshot{}
+	if err := json.Unmarshal([]byte(data), snapshot); err != nil {
+		return nil, fmt.Errorf("could not unmarshal nodeclass snapshot %s: %v", ref, err)
+	}
+	return snapshot, nil
+}
+
+// addSnapshotOwner adds the node as owner to the snapshot it references. E.g. after a migration.
+func (c *Controller) addSnapshotOwner(node *v1.Node) error {
+	return c.addSnapshotOwnerReference(node.Annotations[nodeClassSnapshotAnnotationKey], nodeOwnerReference(node))
+}
+
+func (c *Controller) addSnapshotOwnerReference(ref string, owner metav1.OwnerReference) error {
+	if ref == "" {
+		return nil
+	}
+	namespace, name, err := splitSnapshotReference(ref)
+	if err != nil {
+		return err
+	}
+
+	secret, err := c.client.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get nodeclass snapshot %s: %v", ref, err)
+	}
+	for _, o := range secret.OwnerReferences {
+		if o.UID == owner.UID {
+			return nil
+		}
+	}
+
+	glog.V(6).Infof("Adding %s %s as owner of nodeclass snapshot %s", owner.Kind, owner.Name, ref)
+	secret.OwnerReferences = append(secret.OwnerReferences, owner)
+	_, err = c.client.CoreV1().Secrets(namespace).Update(secret)
+	if err != nil {
+		return fmt.Errorf("failed to update owners of nodeclass snapshot %s: %v", ref, err)
+	}
+	return nil
+}
+
+// nodeClassHash returns the hash of the current nodeclass of the node, as it would be used for a new snapshot
+func nodeClassHash(class *v1alpha1.NodeClass, config *nodeclass.NodeClassConfig) (string, error) {
+	return nodeclass.NewSnapshot(class, config).Hash()
+}
+
+func nodeOwnerReference(node *v1.Node) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       node.Name,
+		UID:        node.UID,
+	}
+}
+
+func splitSnapshotReference(ref string) (string, string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid nodeclass snapshot reference %q", ref)
+	}
+	return parts[0], parts[1], nil
+}
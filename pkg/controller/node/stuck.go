@@ -0,0 +1,154 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	conditionStuck v1.NodeConditionType = "Stuck"
+
+	stuckReasonTimeoutExceeded = "PhaseTimeoutExceeded"
+	stuckReasonWithinTimeout   = "PhaseWithinTimeout"
+)
+
+// stuckNodeWorker flags nodes which are longer in a phase than the timeout of their nodeclass allows
+// with a Stuck condition and a warning event. The condition is reset once the node left the phase.
+// Nodes whose nodeclass does not exist cannot leave their phase, they are flagged after the default timeout.
+func (c *Controller) stuckNodeWorker() {
+	stuck := map[string]int{}
+
+	nlist := c.nodeIndexer.List()
+	for _, obj := range nlist {
+		node := obj.(*v1.Node)
+		if node.DeletionTimestamp != nil || !c.ownsNode(node.Name) {
+			continue
+		}
+		// Nodes without a phase did not get synced yet, e.g. because their nodeclass is missing
+		phase := node.Annotations[phaseAnnotationKey]
+		if phase == "" {
+			phase = phasePending
+		}
+
+		isControllerNode, err := c.isStuckCandidate(node)
+		if err != nil {
+			glog.V(0).Infof("failed to identify if node %s belongs to this controller: %v", node.Name, err)
+			continue
+		}
+		if !isControllerNode {
+			continue
+		}
+
+		timeout, err := c.getPhaseTimeout(node, phase)
+		if err != nil {
+			glog.V(0).Infof("Failed to get timeout of phase %s for node %s: %v", phase, node.Name, err)
+			continue
+		}
+
+		inPhase := time.Since(phaseSince(node))
+		isStuck := timeout > 0 && inPhase > timeout
+		if isStuck {
+			stuck[phase]++
+		}
+
+		if err := c.setStuckCondition(node, phase, isStuck, inPhase, timeout); err != nil {
+			glog.V(0).Infof("Failed to update stuck condition of node %s: %v", node.Name, err)
+		}
+	}
+
+	for _, phase := range []string{phasePending, phaseProvisioning, phaseLaunching} {
+		c.metrics.StuckNodes.WithLabelValues(phase).Set(float64(stuck[phase]))
+	}
+}
+
+// isStuckCandidate returns true if the node belongs to this controller. Nodes whose nodeclass or one of its references
+// is missing belong to this controller, unless the nodeclass exists outside of the cache and specifies another controller.
+func (c *Controller) isStuckCandidate(node *v1.Node) (bool, error) {
+	if node.Annotations[nodeClassSnapshotAnnotationKey] == "" {
+		if _, _, err := c.getBaseNodeClass(node); isNodeClassNotFound(err) {
+			class, err := c.getReferencedNodeClass(node.Annotations[v1alpha1.NodeClassNameAnnotationKey])
+			if err != nil {
+				return false, err
+			}
+			return class == nil || class.NodeController == c.controllerName, nil
+		}
+	}
+	return c.isControllerNode(node)
+}
+
+// getPhaseTimeout returns the timeout of the phase specified by the nodeclass of the node.
+// Falls back to the default timeout if the nodeclass specifies none or is missing.
+func (c *Controller) getPhaseTimeout(node *v1.Node, phase string) (time.Duration, error) {
+	if phase == phaseRunning {
+		return 0, nil
+	}
+	_, config, err := c.getNodeClass(node)
+	if isNodeClassNotFound(err) {
+		return c.defaultPhaseTimeout, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	timeout, err := config.PhaseTimeouts.Timeout(phase)
+	if err != nil || timeout > 0 {
+		return timeout, err
+	}
+	return c.defaultPhaseTimeout, nil
+}
+
+// setStuckCondition updates the Stuck condition if its status changed. Nodes which never got stuck get no condition.
+// A warning event gets recorded whenever a node becomes stuck.
+func (c *Controller) setStuckCondition(node *v1.Node, phase string, isStuck bool, inPhase, timeout time.Duration) error {
+	var existing *v1.NodeCondition
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == conditionStuck {
+			existing = &node.Status.Conditions[i]
+		}
+	}
+
+	con := v1.NodeCondition{
+		Type:               conditionStuck,
+		Status:             v1.ConditionFalse,
+		Reason:             stuckReasonWithinTimeout,
+		Message:            fmt.Sprintf("Node is in phase %s", phase),
+		LastHeartbeatTime:  metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+	}
+	if isStuck {
+		con.Status = v1.ConditionTrue
+		con.Reason = stuckReasonTimeoutExceeded
+		con.Message = fmt.Sprintf("Node is in phase %s since %s, exceeding the timeout of %s", phase, inPhase.Round(time.Second), timeout)
+	}
+
+	if existing == nil && !isStuck {
+		return nil
+	}
+	if existing != nil && existing.Status == con.Status {
+		return nil
+	}
+
+	if isStuck {
+		glog.V(2).Infof("Node %s is stuck in phase %s", node.Name, phase)
+		c.recorder.Event(node, v1.EventTypeWarning, stuckReasonTimeoutExceeded, con.Message)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []v1.NodeCondition{con},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Nodes().Patch(node.Name, types.StrategicMergePatchType, patch, "status")
+	return err
+}
@@ -0,0 +1,105 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestGetPhaseTimeout(t *testing.T) {
+	newClass := func(name, controller, config string) *v1alpha1.NodeClass {
+		class := &v1alpha1.NodeClass{NodeController: controller, Config: runtime.RawExtension{Raw: []byte(config)}}
+		class.Name = name
+		return class
+	}
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	store.Add(newClass("with-timeouts", "kube-machine", `{"phaseTimeouts":{"pending":"15m"}}`))
+	store.Add(newClass("without-timeouts", "kube-machine", `{}`))
+	store.Add(newClass("other-controller", "other", `{"parent":"missing"}`))
+
+	c := &Controller{
+		controllerName:      "kube-machine",
+		nodeClassStore:      store,
+		defaultPhaseTimeout: time.Hour,
+	}
+
+	tests := []struct {
+		name       string
+		nodeClass  string
+		phase      string
+		expected   time.Duration
+		expectNode bool
+	}{
+		{
+			name:       "timeout of the nodeclass",
+			nodeClass:  "with-timeouts",
+			phase:      phasePending,
+			expected:   15 * time.Minute,
+			expectNode: true,
+		},
+		{
+			name:       "default for phases without timeout",
+			nodeClass:  "with-timeouts",
+			phase:      phaseLaunching,
+			expected:   time.Hour,
+			expectNode: true,
+		},
+		{
+			name:       "default for nodeclasses without timeouts",
+			nodeClass:  "without-timeouts",
+			phase:      phaseProvisioning,
+			expected:   time.Hour,
+			expectNode: true,
+		},
+		{
+			name:       "default for missing nodeclasses",
+			nodeClass:  "missing",
+			phase:      phasePending,
+			expected:   time.Hour,
+			expectNode: true,
+		},
+		{
+			name:      "missing parent of a nodeclass of another controller",
+			nodeClass: "other-controller",
+			phase:     phasePending,
+			expected:  time.Hour,
+		},
+		{
+			name:       "running nodes cannot be stuck",
+			nodeClass:  "with-timeouts",
+			phase:      phaseRunning,
+			expectNode: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:        "node1",
+				Annotations: map[string]string{v1alpha1.NodeClassNameAnnotationKey: test.nodeClass},
+			}}
+
+			isControllerNode, err := c.isStuckCandidate(node)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if isControllerNode != test.expectNode {
+				t.Errorf("expected node of this controller %t, got %t", test.expectNode, isControllerNode)
+			}
+
+			timeout, err := c.getPhaseTimeout(node, test.phase)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if timeout != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, timeout)
+			}
+		})
+	}
+}
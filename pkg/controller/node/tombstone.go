@@ -0,0 +1,324 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+
+	"k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	tombstoneNamePrefix  = "node-tombstone-"
+	tombstoneLabelKey    = "node.k8s.io/tombstone"
+	tombstoneNodeKey     = "node"
+	tombstoneDeadlineKey = "deadline"
+
+	tombstoneWorkerCount = 5
+	// tombstoneCheckPeriod is the interval in which tombstones are checked for a sibling until their deadline
+	tombstoneCheckPeriod = 5 * time.Second
+	// tombstoneMaxRetries is the number of failures after which a tombstone is reported as failing.
+	// It is still retried every tombstoneFailedRetryPeriod, as dropping it would leak the instance.
+	tombstoneMaxRetries        = 10
+	tombstoneFailedRetryPeriod = 10 * time.Minute
+)
+
+// newTombstoneInformer watches the tombstones of this controller in the state namespace
+func newTombstoneInformer(client kubernetes.Interface, namespace, controllerName string, queue workqueue.RateLimitingInterface) (cache.Store, cache.Controller) {
+	selector := labels.SelectorFromSet(labels.Set{tombstoneLabelKey: controllerName}).String()
+	return cache.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = selector
+				return client.CoreV1().Secrets(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = selector
+				return client.CoreV1().Secrets(namespace).Watch(options)
+			},
+		},
+		&v1.Secret{},
+		0,
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				key, err := cache.MetaNamespaceKeyFunc(obj)
+				if err == nil {
+					queue.Add(key)
+				}
+			},
+		},
+	)
+}
+
+// createTombstone persists a deleted node until it got migrated or its instance got deleted.
+// Until the deadline, a migration to a node registered by the kubelet is possible.
+// Only the metadata of the node is kept. It is stored in a Secret, as the driver data contains credentials.
+func (c *Controller) createTombstone(node *v1.Node) error {
+	data, err := json.Marshal(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        node.Name,
+			UID:         node.UID,
+			Labels:      node.Labels,
+			Annotations: node.Annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tombstoneName(node.Name, node.UID),
+			Namespace: c.stateNamespace,
+			Labels:    map[string]string{tombstoneLabelKey: c.controllerName},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			tombstoneNodeKey:     data,
+			tombstoneDeadlineKey: []byte(time.Now().Add(c.maxMigrationWaitTime).UTC().Format(time.RFC3339)),
+		},
+	}
+	secret, err = c.client.CoreV1().Secrets(c.stateNamespace).Create(secret)
+	// The tombstone got created by an earlier sync, which failed to remove the finalizer
+	if kerrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create tombstone for node %s: %v", node.Name, err)
+	}
+
+	// The snapshot of the node would get garbage collected together with the node
+	owner := metav1.OwnerReference{APIVersion: "v1", Kind: "Secret", Name: secret.Name, UID: secret.UID}
+	if err := c.addSnapshotOwnerReference(node.Annotations[nodeClassSnapshotAnnotationKey], owner); err != nil {
+		glog.V(0).Infof("Failed to add tombstone of node %s as owner of its nodeclass snapshot: %v", node.Name, err)
+	}
+	return nil
+}
+
+// decodeTombstone returns the node & the deadline persisted in the tombstone
+func decodeTombstone(secret *v1.Secret) (*v1.Node, string, error) {
+	node := &v1.Node{}
+	if err := json.Unmarshal(secret.Data[tombstoneNodeKey], node); err != nil {
+		return nil, "", fmt.Errorf("failed to decode tombstone %s: %v", secret.Name, err)
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	return node, string(secret.Data[tombstoneDeadlineKey]), nil
+}
+
+// enqueueAllTombstones enqueues every tombstone, so tombstones which got assigned to this replica after a rebalancing get picked up
+func (c *Controller) enqueueAllTombstones() {
+	for _, key := range c.tombstoneStore.ListKeys() {
+		c.tombstoneQueue.Add(key)
+	}
+}
+
+func (c *Controller) runTombstoneWorker(stopCh <-chan struct{}) {
+	for c.processNextTombstone(stopCh) {
+	}
+}
+
+// processNextTombstone processes tombstones of this controller. Tombstones of a previous run get resumed.
+// Failures are retried with an exponential backoff.
+func (c *Controller) processNextTombstone(stopCh <-chan struct{}) bool {
+	key, quit := c.tombstoneQueue.Get()
+	if quit {
+		return false
+	}
+	defer c.tombstoneQueue.Done(key)
+
+	// Remaining tombstones get resumed on the next start
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+
+	obj, exists, err := c.tombstoneStore.GetByKey(key.(string))
+	if err != nil {
+		glog.V(0).Infof("Failed to fetch tombstone %s from store: %v", key, err)
+		c.tombstoneQueue.AddRateLimited(key)
+		return true
+	}
+	// Already done, e.g. by another replica
+	if !exists {
+		c.forgetTombstone(key)
+		return true
+	}
+	secret := obj.(*v1.Secret)
+
+	node, deadline, err := decodeTombstone(secret)
+	if err != nil {
+		c.handleTombstoneErr(key, secret, err)
+		return true
+	}
+	if !c.ownsNode(node.Name) {
+		c.forgetTombstone(key)
+		return true
+	}
+
+	c.tombstoneWG.Add(1)
+	c.startTombstone(secret.Name)
+	requeueAfter, err := c.processTombstone(secret, node, deadline)
+	c.finishTombstone(secret.Name)
+	c.tombstoneWG.Done()
+
+	if err != nil {
+		c.handleTombstoneErr(key, secret, err)
+		return true
+	}
+	c.forgetTombstone(key)
+	if requeueAfter > 0 {
+		c.tombstoneQueue.AddAfter(key, requeueAfter)
+	}
+	return true
+}
+
+// handleTombstoneErr retries the tombstone rate limited. Tombstones which failed too often get reported via event & metric
+// and are retried less frequently.
+func (c *Controller) handleTombstoneErr(key interface{}, secret *v1.Secret, err error) {
+	if c.tombstoneQueue.NumRequeues(key) < tombstoneMaxRetries {
+		glog.V(0).Infof("Failed to process tombstone %s, retrying: %v", key, err)
+		c.tombstoneQueue.AddRateLimited(key)
+		return
+	}
+
+	glog.V(0).Infof("Failed to process tombstone %s %d times, retrying in %s: %v", key, tombstoneMaxRetries, tombstoneFailedRetryPeriod, err)
+	c.recorder.Eventf(secret, v1.EventTypeWarning, "TombstoneFailed", "Failed to migrate the deleted node or to delete its instance: %v", err)
+	c.tombstoneLock.Lock()
+	c.tombstonesFailing[secret.Name] = err.Error()
+	c.metrics.FailingTombstones.Set(float64(len(c.tombstonesFailing)))
+	c.tombstoneLock.Unlock()
+
+	c.tombstoneQueue.Forget(key)
+	c.tombstoneQueue.AddAfter(key, tombstoneFailedRetryPeriod)
+}
+
+// forgetTombstone resets the failures of the tombstone
+func (c *Controller) forgetTombstone(key interface{}) {
+	c.tombstoneQueue.Forget(key)
+
+	_, name, err := cache.SplitMetaNamespaceKey(key.(string))
+	if err != nil {
+		return
+	}
+	c.tombstoneLock.Lock()
+	delete(c.tombstonesFailing, name)
+	c.metrics.FailingTombstones.Set(float64(len(c.tombstonesFailing)))
+	c.tombstoneLock.Unlock()
+}
+
+func (c *Controller) startTombstone(name string) {
+	c.tombstoneLock.Lock()
+	defer c.tombstoneLock.Unlock()
+
+	c.tombstonesInProgress[name] = true
+}
+
+func (c *Controller) finishTombstone(name string) {
+	c.tombstoneLock.Lock()
+	defer c.tombstoneLock.Unlock()
+
+	delete(c.tombstonesInProgress, name)
+}
+
+// processTombstone migrates the deleted node if a sibling appeared. Otherwise the instance gets deleted after the deadline.
+// The tombstone is only removed once one of both succeeded. Returns the time after which the tombstone must be checked again.
+func (c *Controller) processTombstone(secret *v1.Secret, node *v1.Node, deadline string) (time.Duration, error) {
+	sibling, method, err := c.findForeignSibling(node)
+	if err == nil {
+		if err := c.migrateNode(node, sibling); err != nil {
+			return 0, fmt.Errorf("failed to migrate node %s: %v", node.Name, err)
+		}
+		glog.V(4).Infof("Migrated node %s to %s", node.Name, sibling.Name)
+		c.recorder.Eventf(sibling, v1.EventTypeNormal, "Migrated", "Migrated node %s to this node (matched by %s)", node.Name, method)
+		c.metrics.Machines.WithLabelValues(machineEventMigrated).Inc()
+		return 0, c.deleteTombstone(secret)
+	}
+	if err != nodeNotFoundErr {
+		return 0, fmt.Errorf("failed to find a sibling for deleted node %s: %v", node.Name, err)
+	}
+
+	if until, err := time.Parse(time.RFC3339, deadline); err == nil && time.Now().Before(until) {
+		glog.V(6).Infof("Waiting until %s to see if a new node appears for migration after %s got deleted", until, node.Name)
+		if remaining := time.Until(until); remaining < tombstoneCheckPeriod {
+			return remaining, nil
+		}
+		return tombstoneCheckPeriod, nil
+	}
+
+	glog.V(4).Infof("No new node found for deleted node %s. Will delete it at cloud-provider", node.Name)
+
+	mapi := libmachine.New()
+	defer mapi.Close()
+
+	h, err := c.LoadHost(mapi, node)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load instance of deleted node %s: %v", node.Name, err)
+	}
+	if err := h.Driver.Remove(); err != nil {
+		return 0, fmt.Errorf("failed to delete instance of deleted node %s: %v", node.Name, err)
+	}
+	c.metrics.Machines.WithLabelValues(machineEventDeleted).Inc()
+	return 0, c.deleteTombstone(secret)
+}
+
+// deleteTombstone removes the tombstone. A tombstone which is already gone counts as removed.
+func (c *Controller) deleteTombstone(secret *v1.Secret) error {
+	err := c.client.CoreV1().Secrets(secret.Namespace).Delete(secret.Name, &metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete tombstone %s: %v", secret.Name, err)
+	}
+	return nil
+}
+
+// waitForTombstones waits until all tombstones in progress are done or the timeout is reached.
+// Unfinished tombstones get resumed on the next start.
+func (c *Controller) waitForTombstones(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.tombstoneWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// tombstoneName returns the name of the tombstone Secret. It contains the uid of the node, as a node which got recreated
+// with the same name needs a tombstone of its own. Names which would be too long get hashed.
+func tombstoneName(nodeName string, uid types.UID) string {
+	id := nodeName
+	if uid != "" {
+		id = fmt.Sprintf("%s-%s", nodeName, uid)
+	}
+	name := tombstoneNamePrefix + id
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		sum := sha256.Sum256([]byte(id))
+		name = tombstoneNamePrefix + hex.EncodeToString(sum[:])[:20]
+	}
+	return name
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+func TestTombstoneName(t *testing.T) {
+	uid := types.UID("2b0f5c1e-4c3a-4a7e-9d7f-0d6c1f0b2a11")
+
+	tests := []struct {
+		name     string
+		nodeName string
+		uid      types.UID
+		expected string
+	}{
+		{
+			name:     "short name",
+			nodeName: "kube-machine-1",
+			uid:      uid,
+			expected: "node-tombstone-kube-machine-1-2b0f5c1e-4c3a-4a7e-9d7f-0d6c1f0b2a11",
+		},
+		{
+			name:     "without uid",
+			nodeName: "kube-machine-1",
+			expected: "node-tombstone-kube-machine-1",
+		},
+		{
+			name:     "too long name",
+			nodeName: strings.Repeat("a", validation.DNS1123SubdomainMaxLength),
+			uid:      uid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name := tombstoneName(test.nodeName, test.uid)
+			if len(name) > validation.DNS1123SubdomainMaxLength {
+				t.Errorf("expected a name of at most %d characters, got %d", validation.DNS1123SubdomainMaxLength, len(name))
+			}
+			if test.expected != "" && name != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, name)
+			}
+		})
+	}
+
+	// A node which got recreated with the same name needs a tombstone of its own
+	if tombstoneName("kube-machine-1", uid) == tombstoneName("kube-machine-1", "a7c5e0d2-9b1f-4f0e-8c3d-5e2a7b9c1d40") {
+		t.Errorf("expected different names for nodes with different uids")
+	}
+	long := strings.Repeat("a", validation.DNS1123SubdomainMaxLength)
+	if tombstoneName(long, uid) == tombstoneName(long, "a7c5e0d2-9b1f-4f0e-8c3d-5e2a7b9c1d40") {
+		t.Errorf("expected different hashed names for nodes with different uids")
+	}
+}
+
+func TestDecodeTombstone(t *testing.T) {
+	data, err := json.Marshal(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "kube-machine-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		data      map[string][]byte
+		expectErr bool
+	}{
+		{
+			name: "valid tombstone",
+			data: map[string][]byte{tombstoneNodeKey: data, tombstoneDeadlineKey: []byte("2020-01-01T00:00:00Z")},
+		},
+		{
+			name:      "invalid node",
+			data:      map[string][]byte{tombstoneNodeKey: []byte("{")},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, deadline, err := decodeTombstone(&v1.Secret{Data: test.data})
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if node.Name != "kube-machine-1" || deadline != "2020-01-01T00:00:00Z" {
+				t.Errorf("unexpected node %s with deadline %s", node.Name, deadline)
+			}
+			// Migrations write into the maps of the decoded node
+			if node.Annotations == nil || node.Labels == nil {
+				t.Errorf("expected annotations & labels to be initialized")
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package nodecommand
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/controller"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// maxOutputBytes limits stdout & stderr per node to keep the resource below the etcd object size limit
+	maxOutputBytes = 32 * 1024
+)
+
+type Controller struct {
+	commandInformer cache.Controller
+	commandStore    cache.Store
+	commandQueue    workqueue.RateLimitingInterface
+	nodeInformer    cache.Controller
+	nodeIndexer     cache.Indexer
+	client          rest.Interface
+	nodeFilter      controller.NodeFilter
+	hostLoader      controller.HostLoader
+	parallelism     int
+}
+
+func New(
+	client rest.Interface,
+	queue workqueue.RateLimitingInterface,
+	commandStore cache.Store,
+	commandInformer cache.Controller,
+	nodeIndexer cache.Indexer,
+	nodeInformer cache.Controller,
+	nodeFilter controller.NodeFilter,
+	hostLoader controller.HostLoader,
+	parallelism int,
+) controller.Interface {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &Controller{
+		commandInformer: commandInformer,
+		commandStore:    commandStore,
+		commandQueue:    queue,
+		nodeInformer:    nodeInformer,
+		nodeIndexer:     nodeIndexer,
+		client:          client,
+		nodeFilter:      nodeFilter,
+		hostLoader:      hostLoader,
+		parallelism:     parallelism,
+	}
+}
+
+func (c *Controller) processNextItem() bool {
+	key, quit := c.commandQueue.Get()
+	if quit {
+		return false
+	}
+
+	defer c.commandQueue.Done(key)
+
+	err := c.syncNodeCommand(key.(string))
+	c.handleErr(err, key)
+	return true
+}
+
+func (c *Controller) syncNodeCommand(key string) error {
+	obj, exists, err := c.commandStore.GetByKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch nodecommand %s from store: %v", key, err)
+	}
+	if !exists {
+		glog.V(6).Infof("NodeCommand %s got deleted", key)
+		return nil
+	}
+
+	cmd := obj.(*nodecommand.NodeCommand).DeepCopy()
+	// Commands are executed exactly once. A command which was interrupted stays in the running phase.
+	if cmd.Status.Phase != "" {
+		return nil
+	}
+
+	nodes, err := c.targetNodes(cmd)
+	if err != nil {
+		cmd.Status.Phase = nodecommand.PhaseFailed
+		cmd.Status.Results = []nodecommand.NodeCommandResult{{Error: err.Error(), StartTime: metav1.Now()}}
+		_, err = c.updateNodeCommandStatus(cmd)
+		return err
+	}
+
+	cmd.Status.Phase = nodecommand.PhaseRunning
+	cmd, err = c.updateNodeCommandStatus(cmd)
+	if err != nil {
+		return err
+	}
+
+	glog.V(2).Infof("Executing NodeCommand %s on %d nodes", key, len(nodes))
+
+	cmd.Status.Results = c.executeAll(nodes, cmd.Spec.Command, cmd.Spec.Timeout())
+	cmd.Status.Phase = nodecommand.PhaseCompleted
+	for _, result := range cmd.Status.Results {
+		if result.Error != "" {
+			cmd.Status.Phase = nodecommand.PhaseFailed
+		}
+	}
+
+	_, err = c.updateNodeCommandStatus(cmd)
+	return err
+}
+
+// executeAll executes the command on the nodes, at most parallelism nodes at once. The results are in the order of the nodes.
+func (c *Controller) executeAll(nodes []*corev1.Node, command string, timeout time.Duration) []nodecommand.NodeCommandResult {
+	results := make([]nodecommand.NodeCommandResult, len(nodes))
+	sem := make(chan struct{}, c.parallelism)
+	wg := sync.WaitGroup{}
+	for i := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = c.execute(nodes[i], command, timeout)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// targetNodes returns all nodes which are specified by name or matched by the selector of the command
+func (c *Controller) targetNodes(cmd *nodecommand.NodeCommand) ([]*corev1.Node, error) {
+	if len(cmd.Spec.NodeNames) == 0 && cmd.Spec.NodeSelector == nil {
+		return nil, errors.New("neither nodeNames nor nodeSelector specified")
+	}
+
+	targets := map[string]*corev1.Node{}
+	for _, name := range cmd.Spec.NodeNames {
+		obj, exists, err := c.nodeIndexer.GetByKey(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch node %s from store: %v", name, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("node %s not found", name)
+		}
+		targets[name] = obj.(*corev1.Node)
+	}
+
+	if cmd.Spec.NodeSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(cmd.Spec.NodeSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector: %v", err)
+		}
+		for _, obj := range c.nodeIndexer.List() {
+			node := obj.(*corev1.Node)
+			if !selector.Matches(labels.Set(node.Labels)) {
+				continue
+			}
+			// Nodes of other controllers are skipped, only explicitly named nodes fail
+			managed, err := c.nodeFilter.ManagesNode(node)
+			if err != nil {
+				return nil, fmt.Errorf("failed to identify if node %s belongs to this controller: %v", node.Name, err)
+			}
+			if managed {
+				targets[node.Name] = node
+			}
+		}
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nodes := make([]*corev1.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, targets[name])
+	}
+	return nodes, nil
+}
+
+func (c *Controller) execute(node *corev1.Node, command string, timeout time.Duration) nodecommand.NodeCommandResult {
+	result := nodecommand.NodeCommandResult{
+		NodeName:  node.Name,
+		StartTime: metav1.Now(),
+	}
+	defer func() {
+		now := metav1.Now()
+		result.CompletionTime = &now
+	}()
+
+	managed, err := c.nodeFilter.ManagesNode(node)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to identify if node belongs to this controller: %v", err)
+		return result
+	}
+	if !managed {
+		result.Error = "node is not managed by this controller"
+		return result
+	}
+
+	mapi := libmachine.New()
+	defer mapi.Close()
+
+	h, err := c.hostLoader.LoadHost(mapi, node)
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to load machine: %v", err)
+		return result
+	}
+
+	glog.V(4).Infof("Executing command %q on node %s", command, node.Name)
+	stdout, stderr, exitCode, err := mapi.RunSSHCommand(h, command, timeout)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Stdout = truncate(stdout)
+	result.Stderr = truncate(stderr)
+	result.ExitCode = exitCode
+	return result
+}
+
+// updateNodeCommandStatus only writes the status, changes of the spec are ignored by the apiserver
+func (c *Controller) updateNodeCommandStatus(cmd *nodecommand.NodeCommand) (*nodecommand.NodeCommand, error) {
+	result := &nodecommand.NodeCommand{}
+	err := c.client.Put().
+		Resource(nodecommand.NodeCommandResourcePlural).
+		Name(cmd.Name).
+		SubResource("status").
+		Body(cmd).
+		Do().
+		Into(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status of nodecommand %s: %v", cmd.Name, err)
+	}
+	return result, nil
+}
+
+func truncate(s string) string {
+	if len(s) <= maxOutputBytes {
+		return s
+	}
+	return s[len(s)-maxOutputBytes:]
+}
+
+// handleErr checks if an error happened and makes sure we will retry later.
+func (c *Controller) handleErr(err error, key interface{}) {
+	if err == nil {
+		c.commandQueue.Forget(key)
+		return
+	}
+
+	if c.commandQueue.NumRequeues(key) < 5 {
+		glog.V(0).Infof("Error syncing nodecommand %v: %v", key, err)
+		c.commandQueue.AddRateLimited(key)
+		return
+	}
+
+	c.commandQueue.Forget(key)
+	runtime.HandleError(err)
+	glog.V(0).Infof("Dropping nodecommand %q out of the queue: %v", key, err)
+}
+
+func (c *Controller) Run(workerCount int, stopCh chan struct{}) {
+	defer runtime.HandleCrash()
+
+	defer c.commandQueue.ShutDown()
+	glog.V(0).Info("Starting NodeCommand controller")
+
+	go c.commandInformer.Run(stopCh)
+
+	// The node informer gets started by the node controller
+	if !cache.WaitForCacheSync(stopCh, c.commandInformer.HasSynced, c.nodeInformer.HasSynced) {
+		runtime.HandleError(errors.New("timed out waiting for caches to sync"))
+		return
+	}
+
+	for i := 0; i < workerCount; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	glog.V(0).Info("Stopping NodeCommand controller")
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *Controller) IsReady() bool {
+	return c.commandInformer.HasSynced()
+}
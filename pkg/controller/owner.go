@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"github.com/docker/machine/libmachine/host"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+
+	"k8s.io/api/core/v1"
+)
+
+// NodeFilter is implemented by controllers which can tell whether they manage a node
+type NodeFilter interface {
+	// ManagesNode returns true if the node was created by this controller and is managed by it
+	ManagesNode(node *v1.Node) (bool, error)
+}
+
+// HostLoader is implemented by controllers which can load the docker machine host of their nodes
+type HostLoader interface {
+	// LoadHost loads the host of the node, including everything its driver needs
+	LoadHost(mapi *libmachine.Client, node *v1.Node) (*host.Host, error)
+}
@@ -1,9 +1,15 @@
 package libmachine
 
 import (
+	"bytes"
 	"fmt"
+	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/docker/machine/drivers/errdriver"
 	"github.com/docker/machine/libmachine/auth"
@@ -14,10 +20,12 @@ import (
 	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/log"
 	"github.com/docker/machine/libmachine/mcnerror"
+	mcnssh "github.com/docker/machine/libmachine/ssh"
 	"github.com/docker/machine/libmachine/swarm"
 	"github.com/docker/machine/libmachine/version"
 	"github.com/kube-node/kube-machine/pkg/nodeclass"
 	"github.com/kube-node/kube-machine/pkg/provision"
+	"golang.org/x/crypto/ssh"
 
 	"k8s.io/api/core/v1"
 )
@@ -52,7 +60,7 @@ func (api *Client) NewHost(driverName string, rawDriver []byte) (*host.Host, err
 	return &host.Host{
 		ConfigVersion: version.ConfigVersion,
 		Name:          driver.GetMachineName(),
-		Driver:        driver,
+		Driver:        instrument(driver, driverName),
 		DriverName:    driver.DriverName(),
 		HostOptions: &host.Options{
 			AuthOptions: &auth.Options{
@@ -94,9 +102,9 @@ func (api *Client) Load(node *v1.Node) (*host.Host, error) {
 	}
 
 	if h.DriverName == "virtualbox" {
-		h.Driver = drivers.NewSerialDriver(d)
+		h.Driver = instrument(drivers.NewSerialDriver(d), h.DriverName)
 	} else {
-		h.Driver = d
+		h.Driver = instrument(d, h.DriverName)
 	}
 
 	return h, nil
@@ -141,3 +149,93 @@ func (api *Client) Provision(h *host.Host, config *nodeclass.NodeClassConfig) er
 func (api *Client) Close() error {
 	return api.clientDriverFactory.Close()
 }
+
+// RunSSHCommand executes the given command on the host via SSH and returns stdout, stderr and the exit code separately.
+// An error is only returned if the command could not be executed at all or did not finish within the timeout.
+// After the timeout the ssh session of the native client gets closed, the process of the external client gets killed.
+func (api *Client) RunSSHCommand(h *host.Host, command string, timeout time.Duration) (stdout, stderr string, exitCode int, err error) {
+	client, err := drivers.GetSSHClientFromDriver(h.Driver)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to create ssh client: %v", err)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	var wait func() error
+	var abort func()
+	switch c := client.(type) {
+	case *mcnssh.NativeClient:
+		conn, err := ssh.Dial("tcp", net.JoinHostPort(c.Hostname, strconv.Itoa(c.Port)), &c.Config)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("failed to connect via ssh: %v", err)
+		}
+		defer conn.Close()
+		session, err := conn.NewSession()
+		if err != nil {
+			return "", "", 0, fmt.Errorf("failed to create ssh session: %v", err)
+		}
+		defer session.Close()
+		session.Stdout = &outBuf
+		session.Stderr = &errBuf
+		if err := session.Start(command); err != nil {
+			return "", "", 0, fmt.Errorf("failed to start ssh command: %v", err)
+		}
+		wait = session.Wait
+		abort = func() {
+			session.Signal(ssh.SIGKILL)
+			session.Close()
+			conn.Close()
+		}
+	case *mcnssh.ExternalClient:
+		args := append(append([]string{}, c.BaseArgs...), command)
+		cmd := exec.Command(c.BinaryPath, args...)
+		cmd.Stdout = &outBuf
+		cmd.Stderr = &errBuf
+		if err := cmd.Start(); err != nil {
+			return "", "", 0, fmt.Errorf("failed to start ssh command: %v", err)
+		}
+		wait = cmd.Wait
+		abort = func() {
+			cmd.Process.Kill()
+		}
+	default:
+		return "", "", 0, fmt.Errorf("unsupported ssh client %T", client)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		abort()
+		<-done
+		return "", "", 0, fmt.Errorf("ssh command did not finish within %s", timeout)
+	}
+
+	if err != nil {
+		code, ok := sshExitCode(err)
+		if !ok {
+			return outBuf.String(), errBuf.String(), 0, fmt.Errorf("failed to run ssh command: %v", err)
+		}
+		return outBuf.String(), errBuf.String(), code, nil
+	}
+
+	return outBuf.String(), errBuf.String(), 0, nil
+}
+
+// sshExitCode extracts the exit code of a remote command from the error of the native or the external ssh client
+func sshExitCode(err error) (int, bool) {
+	if e, ok := err.(interface {
+		ExitStatus() int
+	}); ok {
+		return e.ExitStatus(), true
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if s, ok := e.Sys().(syscall.WaitStatus); ok {
+			return s.ExitStatus(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,90 @@
+package libmachine
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// DriverCallObserver gets called after every call of a driver method which talks to the cloud provider
+type DriverCallObserver func(driverName, method string, duration time.Duration, err error)
+
+var driverCallObserver DriverCallObserver
+
+// SetDriverCallObserver sets the observer for all drivers loaded afterwards
+func SetDriverCallObserver(o DriverCallObserver) {
+	driverCallObserver = o
+}
+
+// instrumentedDriver reports the duration of the driver calls to the observer
+type instrumentedDriver struct {
+	drivers.Driver
+	driverName string
+	observer   DriverCallObserver
+}
+
+func instrument(d drivers.Driver, driverName string) drivers.Driver {
+	if driverCallObserver == nil {
+		return d
+	}
+	return &instrumentedDriver{Driver: d, driverName: driverName, observer: driverCallObserver}
+}
+
+// MarshalJSON keeps the driver data in the format of the wrapped driver
+func (d *instrumentedDriver) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Driver)
+}
+
+// call runs the driver method and reports its duration
+func (d *instrumentedDriver) call(method string, f func() error) error {
+	start := time.Now()
+	err := f()
+	d.observer(d.driverName, method, time.Since(start), err)
+	return err
+}
+
+func (d *instrumentedDriver) Create() error {
+	return d.call("Create", d.Driver.Create)
+}
+
+func (d *instrumentedDriver) PreCreateCheck() error {
+	return d.call("PreCreateCheck", d.Driver.PreCreateCheck)
+}
+
+func (d *instrumentedDriver) GetIP() (ip string, err error) {
+	err = d.call("GetIP", func() error {
+		ip, err = d.Driver.GetIP()
+		return err
+	})
+	return ip, err
+}
+
+func (d *instrumentedDriver) GetState() (s state.State, err error) {
+	err = d.call("GetState", func() error {
+		s, err = d.Driver.GetState()
+		return err
+	})
+	return s, err
+}
+
+func (d *instrumentedDriver) Start() error {
+	return d.call("Start", d.Driver.Start)
+}
+
+func (d *instrumentedDriver) Stop() error {
+	return d.call("Stop", d.Driver.Stop)
+}
+
+func (d *instrumentedDriver) Restart() error {
+	return d.call("Restart", d.Driver.Restart)
+}
+
+func (d *instrumentedDriver) Kill() error {
+	return d.call("Kill", d.Driver.Kill)
+}
+
+func (d *instrumentedDriver) Remove() error {
+	return d.call("Remove", d.Driver.Remove)
+}
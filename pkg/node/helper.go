@@ -1,9 +1,15 @@
 package node
 
 import (
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
 	"k8s.io/api/core/v1"
 )
 
+// TempReadyConditionReason is the reason of the ready condition which kube-machine sets until the kubelet reports
+const TempReadyConditionReason = "Kubelet is being provisioned by the nodecontroller"
+
 func HasFinalizer(n *v1.Node, name string) bool {
 	for _, f := range n.Finalizers {
 		if f == name {
@@ -22,14 +28,36 @@ func HasTaint(n *v1.Node, key string) bool {
 	return false
 }
 
-func HasJoined(n *v1.Node) bool {
-	if len(n.Status.Conditions) == 0 {
+// HasJoined returns true if the kubelet of the node reported its status. If the Lease is required, e.g. because
+// kube-machine renewed it until the kubelet joined, the kubelet must have taken it over as well.
+func HasJoined(n *v1.Node, lease *coordinationv1.Lease, leaseRequired bool) bool {
+	if !KubeletReported(n) {
+		return false
+	}
+	return !leaseRequired || KubeletLeaseRenewed(n, lease)
+}
+
+// KubeletReported returns true if the kubelet populated the node info and reported a ready condition
+func KubeletReported(n *v1.Node) bool {
+	if n.Status.NodeInfo.KubeletVersion == "" {
 		return false
 	}
 	for _, c := range n.Status.Conditions {
-		if c.Reason == "NodeStatusNeverUpdated" {
-			return false
+		if c.Type == v1.NodeReady {
+			return c.Reason != TempReadyConditionReason && c.Reason != "NodeStatusNeverUpdated"
 		}
 	}
-	return true
+	return false
+}
+
+// KubeletLeaseRenewed returns true if the kubelet of the node holds its Lease and renewed it within the lease duration
+func KubeletLeaseRenewed(n *v1.Node, lease *coordinationv1.Lease) bool {
+	if lease == nil || lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != n.Name {
+		return false
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().Before(expires)
 }
@@ -0,0 +1,39 @@
+package nodeclass
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// InWindow returns true if the given time is within any of the hibernation windows.
+// We are within a window if the next end of the window comes before its next start.
+func (h *NodeClassHibernation) InWindow(now time.Time) (bool, error) {
+	loc := time.UTC
+	if h.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(h.TimeZone)
+		if err != nil {
+			return false, fmt.Errorf("invalid time zone %q: %v", h.TimeZone, err)
+		}
+	}
+	now = now.In(loc)
+
+	for _, s := range h.Schedules {
+		start, err := cron.ParseStandard(s.Start)
+		if err != nil {
+			return false, fmt.Errorf("invalid start %q: %v", s.Start, err)
+		}
+		end, err := cron.ParseStandard(s.End)
+		if err != nil {
+			return false, fmt.Errorf("invalid end %q: %v", s.End, err)
+		}
+
+		if end.Next(now).Before(start.Next(now)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
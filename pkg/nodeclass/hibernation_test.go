@@ -0,0 +1,106 @@
+package nodeclass
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInWindow(t *testing.T) {
+	workingNights := []NodeClassHibernationSchedule{{Start: "0 20 * * 1-5", End: "0 7 * * 1-5"}}
+
+	tests := []struct {
+		name        string
+		hibernation NodeClassHibernation
+		now         time.Time
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			name:        "monday evening is within the window",
+			hibernation: NodeClassHibernation{Schedules: workingNights},
+			now:         time.Date(2021, 3, 1, 21, 0, 0, 0, time.UTC),
+			expected:    true,
+		},
+		{
+			name:        "monday noon is outside of the window",
+			hibernation: NodeClassHibernation{Schedules: workingNights},
+			now:         time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+			expected:    false,
+		},
+		{
+			name:        "the window of friday lasts until monday morning",
+			hibernation: NodeClassHibernation{Schedules: workingNights},
+			now:         time.Date(2021, 3, 6, 12, 0, 0, 0, time.UTC),
+			expected:    true,
+		},
+		{
+			name:        "the window ends at its end",
+			hibernation: NodeClassHibernation{Schedules: workingNights},
+			now:         time.Date(2021, 3, 2, 7, 0, 0, 0, time.UTC),
+			expected:    false,
+		},
+		{
+			name:        "schedules are evaluated in the time zone",
+			hibernation: NodeClassHibernation{Schedules: workingNights, TimeZone: "Europe/Berlin"},
+			now:         time.Date(2021, 3, 1, 19, 30, 0, 0, time.UTC),
+			expected:    true,
+		},
+		{
+			name:        "before the window in the time zone",
+			hibernation: NodeClassHibernation{Schedules: workingNights, TimeZone: "Europe/Berlin"},
+			now:         time.Date(2021, 3, 1, 18, 30, 0, 0, time.UTC),
+			expected:    false,
+		},
+		{
+			name: "any matching schedule",
+			hibernation: NodeClassHibernation{Schedules: []NodeClassHibernationSchedule{
+				{Start: "0 1 * * *", End: "0 2 * * *"},
+				{Start: "0 12 * * *", End: "0 13 * * *"},
+			}},
+			now:      time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC),
+			expected: true,
+		},
+		{
+			name:        "no schedules",
+			hibernation: NodeClassHibernation{},
+			now:         time.Date(2021, 3, 1, 12, 30, 0, 0, time.UTC),
+			expected:    false,
+		},
+		{
+			name:        "invalid start",
+			hibernation: NodeClassHibernation{Schedules: []NodeClassHibernationSchedule{{Start: "0 25 * * *", End: "0 7 * * *"}}},
+			now:         time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+			expectErr:   true,
+		},
+		{
+			name:        "invalid end",
+			hibernation: NodeClassHibernation{Schedules: []NodeClassHibernationSchedule{{Start: "0 20 * * *", End: "every morning"}}},
+			now:         time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+			expectErr:   true,
+		},
+		{
+			name:        "invalid time zone",
+			hibernation: NodeClassHibernation{Schedules: workingNights, TimeZone: "Mars/Olympus_Mons"},
+			now:         time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC),
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inWindow, err := test.hibernation.InWindow(test.now)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if inWindow != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, inWindow)
+			}
+		})
+	}
+}
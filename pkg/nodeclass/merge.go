@@ -0,0 +1,81 @@
+package nodeclass
+
+// Merge returns a new config with the override config merged on top of the base config.
+//   - dockerMachineFlags are merged by key
+//   - files are merged by path and users by name. Entries of override replace the ones of base
+//   - commands of override are appended to the ones of base
+//   - provider, hibernation, migration & phase timeouts are taken from override if set
+//
+// Parent & fragments are not part of the result, as they are resolved by merging.
+func Merge(base, override *NodeClassConfig) *NodeClassConfig {
+	result := &NodeClassConfig{
+		DockerMachineFlags: map[string]string{},
+		Provider:           base.Provider,
+		Hibernation:        base.Hibernation,
+		Migration:          base.Migration,
+		PhaseTimeouts:      base.PhaseTimeouts,
+	}
+
+	for k, v := range base.DockerMachineFlags {
+		result.DockerMachineFlags[k] = v
+	}
+	for k, v := range override.DockerMachineFlags {
+		result.DockerMachineFlags[k] = v
+	}
+
+	if override.Provider != "" {
+		result.Provider = override.Provider
+	}
+	if override.Hibernation != nil {
+		result.Hibernation = override.Hibernation
+	}
+	if override.Migration != nil {
+		result.Migration = override.Migration
+	}
+	if override.PhaseTimeouts != nil {
+		result.PhaseTimeouts = override.PhaseTimeouts
+	}
+
+	result.Provisioning.Files = append(result.Provisioning.Files, base.Provisioning.Files...)
+	for _, f := range override.Provisioning.Files {
+		replaced := false
+		for i := range result.Provisioning.Files {
+			if result.Provisioning.Files[i].Path == f.Path {
+				result.Provisioning.Files[i] = f
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			result.Provisioning.Files = append(result.Provisioning.Files, f)
+		}
+	}
+
+	result.Provisioning.Users = append(result.Provisioning.Users, base.Provisioning.Users...)
+	for _, u := range override.Provisioning.Users {
+		replaced := false
+		for i := range result.Provisioning.Users {
+			if result.Provisioning.Users[i].Name == u.Name {
+				result.Provisioning.Users[i] = u
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			result.Provisioning.Users = append(result.Provisioning.Users, u)
+		}
+	}
+
+	result.Provisioning.Commands = append(result.Provisioning.Commands, base.Provisioning.Commands...)
+	result.Provisioning.Commands = append(result.Provisioning.Commands, override.Provisioning.Commands...)
+
+	keys := map[string]bool{}
+	for _, k := range append(base.OverridableKeys, override.OverridableKeys...) {
+		if !keys[k] {
+			keys[k] = true
+			result.OverridableKeys = append(result.OverridableKeys, k)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,41 @@
+package nodeclass
+
+import (
+	"fmt"
+)
+
+// Methods to match the node registered by a kubelet to the node created by kube-machine
+const (
+	MatchByName       = "name"
+	MatchByHostname   = "hostname"
+	MatchByAddress    = "address"
+	MatchByProviderID = "providerID"
+	MatchBySystemUUID = "systemUUID"
+)
+
+var allMatchMethods = []string{MatchByName, MatchByHostname, MatchByAddress, MatchByProviderID, MatchBySystemUUID}
+
+// MatchMethods returns the enabled match methods in the order they get checked. Defaults to all methods.
+func (m *NodeClassMigration) MatchMethods() []string {
+	if m == nil || len(m.MatchBy) == 0 {
+		return allMatchMethods
+	}
+	return m.MatchBy
+}
+
+// Validate checks that only known match methods are specified
+func (m *NodeClassMigration) Validate() error {
+	for _, method := range m.MatchMethods() {
+		known := false
+		for _, k := range allMatchMethods {
+			if method == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown match method %q, must be one of %v", method, allMatchMethods)
+		}
+	}
+	return nil
+}
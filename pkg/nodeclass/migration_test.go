@@ -0,0 +1,38 @@
+package nodeclass
+
+import (
+	"testing"
+)
+
+func TestNodeClassMigrationValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		migration *NodeClassMigration
+		expectErr bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:      "known methods",
+			migration: &NodeClassMigration{MatchBy: []string{MatchBySystemUUID, MatchByProviderID, MatchByAddress}},
+		},
+		{
+			name:      "unknown method",
+			migration: &NodeClassMigration{MatchBy: []string{MatchByName, "labels"}},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.migration.Validate()
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
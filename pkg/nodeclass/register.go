@@ -3,37 +3,125 @@ package nodeclass
 import (
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
+	"github.com/golang/glog"
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
 	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
 
-	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/apimachinery/pkg/api/equality"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+var ageColumn = apiextensionsv1.CustomResourceColumnDefinition{
+	Name:     "Age",
+	Type:     "date",
+	JSONPath: ".metadata.creationTimestamp",
+}
+
+// EnsureCustomResourceDefinitions creates the custom resource definitions or updates them if they differ.
+// Versions which are still used to store objects are kept as served versions.
 func EnsureCustomResourceDefinitions(clientset apiextensionsclient.Interface) error {
 	type resource struct {
-		plural string
-		kind   string
+		plural       string
+		kind         string
+		group        string
+		version      string
+		scope        apiextensionsv1.ResourceScope
+		schema       *apiextensionsv1.JSONSchemaProps
+		subresources *apiextensionsv1.CustomResourceSubresources
+		columns      []apiextensionsv1.CustomResourceColumnDefinition
 	}
 
 	resourceNames := []resource{
 		{
-			plural: v1alpha1.NodeSetResourcePlural,
-			kind:   reflect.TypeOf(v1alpha1.NodeSet{}).Name(),
+			plural:  v1alpha1.NodeSetResourcePlural,
+			kind:    reflect.TypeOf(v1alpha1.NodeSet{}).Name(),
+			group:   v1alpha1.GroupName,
+			version: v1alpha1.SchemeGroupVersion.Version,
+			scope:   apiextensionsv1.ClusterScoped,
+			schema:  NewSchema(v1alpha1.NodeSet{}, nil),
+			subresources: &apiextensionsv1.CustomResourceSubresources{
+				Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+			},
+			columns: []apiextensionsv1.CustomResourceColumnDefinition{ageColumn},
 		},
 		{
-			plural: v1alpha1.NodeClassResourcePlural,
-			kind:   reflect.TypeOf(v1alpha1.NodeClass{}).Name(),
+			plural:  v1alpha1.NodeClassResourcePlural,
+			kind:    reflect.TypeOf(v1alpha1.NodeClass{}).Name(),
+			group:   v1alpha1.GroupName,
+			version: v1alpha1.SchemeGroupVersion.Version,
+			scope:   apiextensionsv1.ClusterScoped,
+			schema:  NewSchema(v1alpha1.NodeClass{}, map[string]interface{}{"config": NodeClassConfig{}}),
+			columns: []apiextensionsv1.CustomResourceColumnDefinition{
+				{
+					Name:     "Provider",
+					Type:     "string",
+					JSONPath: ".config.provider",
+				},
+				{
+					Name:     "Controller",
+					Type:     "string",
+					JSONPath: ".nodeController",
+				},
+				ageColumn,
+			},
+		},
+		{
+			plural:  nodecommand.NodeCommandResourcePlural,
+			kind:    reflect.TypeOf(nodecommand.NodeCommand{}).Name(),
+			group:   nodecommand.GroupName,
+			version: nodecommand.SchemeGroupVersion.Version,
+			// NodeCommands target nodes of the whole cluster, so they must not be creatable per namespace
+			scope:  apiextensionsv1.ClusterScoped,
+			schema: NewSchema(nodecommand.NodeCommand{}, nil),
+			subresources: &apiextensionsv1.CustomResourceSubresources{
+				Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+			},
+			columns: []apiextensionsv1.CustomResourceColumnDefinition{
+				{
+					Name:     "Phase",
+					Type:     "string",
+					JSONPath: ".status.phase",
+				},
+				ageColumn,
+			},
 		},
 	}
 
 	for _, res := range resourceNames {
-		if err := createCustomResourceDefinition(res.plural, res.kind, clientset); err != nil {
+		crd := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: res.plural + "." + res.group,
+			},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Group: res.group,
+				Scope: res.scope,
+				Names: apiextensionsv1.CustomResourceDefinitionNames{
+					Plural: res.plural,
+					Kind:   res.kind,
+				},
+				Versions: []apiextensionsv1.CustomResourceDefinitionVersion{
+					{
+						Name:    res.version,
+						Served:  true,
+						Storage: true,
+						Schema: &apiextensionsv1.CustomResourceValidation{
+							OpenAPIV3Schema: res.schema,
+						},
+						Subresources:             res.subresources,
+						AdditionalPrinterColumns: res.columns,
+					},
+				},
+			},
+		}
+		if err := ensureCustomResourceDefinition(crd, clientset); err != nil {
 			return err
 		}
 	}
@@ -41,56 +129,173 @@ func EnsureCustomResourceDefinitions(clientset apiextensionsclient.Interface) er
 	return nil
 }
 
-func createCustomResourceDefinition(plural, kind string, clientset apiextensionsclient.Interface) error {
-	name := plural + "." + v1alpha1.GroupName
-	crd := &apiextensionsv1beta1.CustomResourceDefinition{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-		Spec: apiextensionsv1beta1.CustomResourceDefinitionSpec{
-			Group:   v1alpha1.GroupName,
-			Version: v1alpha1.SchemeGroupVersion.Version,
-			Scope:   apiextensionsv1beta1.ClusterScoped,
-			Names: apiextensionsv1beta1.CustomResourceDefinitionNames{
-				Plural: plural,
-				Kind:   kind,
-			},
-		},
+func ensureCustomResourceDefinition(crd *apiextensionsv1.CustomResourceDefinition, clientset apiextensionsclient.Interface) error {
+	name := crd.Name
+	existing, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
+	switch {
+	case kerrors.IsNotFound(err):
+		glog.V(2).Infof("Creating custom resource definition %s", name)
+		_, err = clientset.ApiextensionsV1().CustomResourceDefinitions().Create(crd)
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case existing.Spec.Scope != crd.Spec.Scope:
+		// The scope is immutable. Deleting the CRD would delete all its objects.
+		return fmt.Errorf("scope of custom resource definition %s changed from %s to %s, it must be deleted manually", name, existing.Spec.Scope, crd.Spec.Scope)
+	default:
+		crd.Spec.Versions = mergeVersions(crd.Spec.Versions, existing)
+		// Take over fields which get defaulted by the apiserver
+		if crd.Spec.Conversion == nil {
+			crd.Spec.Conversion = existing.Spec.Conversion
+		}
+		if crd.Spec.Names.Singular == "" {
+			crd.Spec.Names.Singular = existing.Spec.Names.Singular
+		}
+		if crd.Spec.Names.ListKind == "" {
+			crd.Spec.Names.ListKind = existing.Spec.Names.ListKind
+		}
+		if equality.Semantic.DeepEqual(existing.Spec, crd.Spec) {
+			return nil
+		}
+
+		glog.V(2).Infof("Updating custom resource definition %s", name)
+		updated := existing.DeepCopy()
+		updated.Spec = crd.Spec
+		_, err = clientset.ApiextensionsV1().CustomResourceDefinitions().Update(updated)
+		if err != nil {
+			return fmt.Errorf("failed to update custom resource definition %s: %v", name, err)
+		}
+		// The CRD is already established. Deleting it on failure would delete all its objects.
+		return waitForEstablished(name, clientset)
 	}
-	_, err := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Create(crd)
+
+	err = waitForEstablished(name, clientset)
 	if err != nil {
-		if kerrors.IsAlreadyExists(err) {
-			return nil
+		deleteErr := clientset.ApiextensionsV1().CustomResourceDefinitions().Delete(name, nil)
+		if deleteErr != nil {
+			return errors.NewAggregate([]error{err, deleteErr})
 		}
 		return err
 	}
+	return nil
+}
+
+// mergeVersions returns the desired versions plus all versions of the existing CRD
+// which are still used to store objects. The desired storage version stays the only storage version.
+func mergeVersions(desired []apiextensionsv1.CustomResourceDefinitionVersion, existing *apiextensionsv1.CustomResourceDefinition) []apiextensionsv1.CustomResourceDefinitionVersion {
+	versions := append([]apiextensionsv1.CustomResourceDefinitionVersion{}, desired...)
+	known := map[string]bool{}
+	for _, v := range desired {
+		known[v.Name] = true
+	}
 
-	// wait for CRD being established
-	err = wait.Poll(500*time.Millisecond, 60*time.Second, func() (bool, error) {
-		crd, err = clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
+	for _, stored := range existing.Status.StoredVersions {
+		if known[stored] {
+			continue
+		}
+		known[stored] = true
+
+		old := apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:   stored,
+			Served: true,
+			Schema: &apiextensionsv1.CustomResourceValidation{
+				OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+					Type:                   "object",
+					XPreserveUnknownFields: &preserveUnknown,
+				},
+			},
+		}
+		for _, v := range existing.Spec.Versions {
+			if v.Name == stored && v.Schema != nil {
+				old = *v.DeepCopy()
+			}
+		}
+		old.Storage = false
+		versions = append(versions, old)
+	}
+
+	return versions
+}
+
+func waitForEstablished(name string, clientset apiextensionsclient.Interface) error {
+	return wait.Poll(500*time.Millisecond, 60*time.Second, func() (bool, error) {
+		crd, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
 		if err != nil {
 			return false, err
 		}
 		for _, cond := range crd.Status.Conditions {
 			switch cond.Type {
-			case apiextensionsv1beta1.Established:
-				if cond.Status == apiextensionsv1beta1.ConditionTrue {
+			case apiextensionsv1.Established:
+				if cond.Status == apiextensionsv1.ConditionTrue {
 					return true, err
 				}
-			case apiextensionsv1beta1.NamesAccepted:
-				if cond.Status == apiextensionsv1beta1.ConditionFalse {
+			case apiextensionsv1.NamesAccepted:
+				if cond.Status == apiextensionsv1.ConditionFalse {
 					fmt.Printf("Name conflict: %v\n", cond.Reason)
 				}
 			}
 		}
 		return false, err
 	})
-	if err != nil {
-		deleteErr := clientset.ApiextensionsV1beta1().CustomResourceDefinitions().Delete(name, nil)
-		if deleteErr != nil {
-			return errors.NewAggregate([]error{err, deleteErr})
+}
+
+// CheckCustomResourceDefinitions returns an error if one of the custom resource definitions is missing or not established
+func CheckCustomResourceDefinitions(clientset apiextensionsclient.Interface) error {
+	names := []string{
+		v1alpha1.NodeSetResourcePlural + "." + v1alpha1.GroupName,
+		v1alpha1.NodeClassResourcePlural + "." + v1alpha1.GroupName,
+		nodecommand.NodeCommandResourcePlural + "." + nodecommand.GroupName,
+	}
+
+	for _, name := range names {
+		crd, err := clientset.ApiextensionsV1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get custom resource definition %s: %v", name, err)
+		}
+		if !isEstablished(crd) {
+			return fmt.Errorf("custom resource definition %s is not established", name)
 		}
-		return err
 	}
 	return nil
 }
+
+// CustomResourceDefinitionChecker caches the result of CheckCustomResourceDefinitions, so frequent readiness probes
+// do not cause requests to the apiserver on every call
+type CustomResourceDefinitionChecker struct {
+	clientset apiextensionsclient.Interface
+	interval  time.Duration
+
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func NewCustomResourceDefinitionChecker(clientset apiextensionsclient.Interface, interval time.Duration) *CustomResourceDefinitionChecker {
+	return &CustomResourceDefinitionChecker{
+		clientset: clientset,
+		interval:  interval,
+	}
+}
+
+// Check returns the result of the last check if it is younger than the interval, otherwise the custom resource definitions get checked
+func (c *CustomResourceDefinitionChecker) Check() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.checked.IsZero() || time.Since(c.checked) >= c.interval {
+		c.err = CheckCustomResourceDefinitions(c.clientset)
+		c.checked = time.Now()
+	}
+	return c.err
+}
+
+func isEstablished(crd *apiextensionsv1.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == apiextensionsv1.Established {
+			return cond.Status == apiextensionsv1.ConditionTrue
+		}
+	}
+	return false
+}
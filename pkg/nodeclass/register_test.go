@@ -0,0 +1,95 @@
+package nodeclass
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestCRD(name string, established apiextensionsv1.ConditionStatus) *apiextensionsv1.CustomResourceDefinition {
+	return &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			Conditions: []apiextensionsv1.CustomResourceDefinitionCondition{
+				{Type: apiextensionsv1.Established, Status: established},
+			},
+		},
+	}
+}
+
+func TestCheckCustomResourceDefinitions(t *testing.T) {
+	nodeSets := v1alpha1.NodeSetResourcePlural + "." + v1alpha1.GroupName
+	nodeClasses := v1alpha1.NodeClassResourcePlural + "." + v1alpha1.GroupName
+	nodeCommands := nodecommand.NodeCommandResourcePlural + "." + nodecommand.GroupName
+
+	tests := []struct {
+		name      string
+		crds      []runtime.Object
+		expectErr bool
+	}{
+		{
+			name: "all established",
+			crds: []runtime.Object{
+				newTestCRD(nodeSets, apiextensionsv1.ConditionTrue),
+				newTestCRD(nodeClasses, apiextensionsv1.ConditionTrue),
+				newTestCRD(nodeCommands, apiextensionsv1.ConditionTrue),
+			},
+		},
+		{
+			name: "one missing",
+			crds: []runtime.Object{
+				newTestCRD(nodeSets, apiextensionsv1.ConditionTrue),
+				newTestCRD(nodeClasses, apiextensionsv1.ConditionTrue),
+			},
+			expectErr: true,
+		},
+		{
+			name: "one not established",
+			crds: []runtime.Object{
+				newTestCRD(nodeSets, apiextensionsv1.ConditionTrue),
+				newTestCRD(nodeClasses, apiextensionsv1.ConditionFalse),
+				newTestCRD(nodeCommands, apiextensionsv1.ConditionTrue),
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckCustomResourceDefinitions(fake.NewSimpleClientset(test.crds...))
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCustomResourceDefinitionCheckerCachesResult(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	checker := NewCustomResourceDefinitionChecker(clientset, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := checker.Check(); err == nil {
+			t.Fatalf("expected an error for missing custom resource definitions, got none")
+		}
+	}
+	if actions := len(clientset.Actions()); actions != 1 {
+		t.Errorf("expected the result of the first check to be cached, got %d requests", actions)
+	}
+
+	checker.interval = 0
+	checker.Check()
+	if actions := len(clientset.Actions()); actions != 2 {
+		t.Errorf("expected a new check after the interval, got %d requests", actions)
+	}
+}
@@ -0,0 +1,108 @@
+package nodeclass
+
+import (
+	"reflect"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+var (
+	preserveUnknown  = true
+	specialTypeNames = map[string]apiextensionsv1.JSONSchemaProps{
+		// metav1.ObjectMeta gets validated by the apiserver itself
+		"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta": {Type: "object"},
+		"k8s.io/apimachinery/pkg/apis/meta/v1.Time":       {Type: "string", Format: "date-time", Nullable: true},
+		"k8s.io/apimachinery/pkg/runtime.RawExtension":    {Type: "object", XPreserveUnknownFields: &preserveUnknown},
+	}
+)
+
+// NewSchema returns a structural OpenAPI v3 schema for the given object, derived from its type and json tags.
+// Fields can be replaced with the schema of another type via overrides. E.g. to validate raw config fields.
+func NewSchema(obj interface{}, overrides map[string]interface{}) *apiextensionsv1.JSONSchemaProps {
+	s := schemaFor(reflect.TypeOf(obj))
+	for name, o := range overrides {
+		s.Properties[name] = schemaFor(reflect.TypeOf(o))
+	}
+	return &s
+}
+
+func schemaFor(t reflect.Type) apiextensionsv1.JSONSchemaProps {
+	pkg := t.PkgPath()
+	// Vendored packages have the path of the vendor directory as prefix
+	if i := strings.LastIndex(pkg, "/vendor/"); i >= 0 {
+		pkg = pkg[i+len("/vendor/"):]
+	}
+	if s, exists := specialTypeNames[pkg+"."+t.Name()]; exists {
+		return s
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := schemaFor(t.Elem())
+		s.Nullable = true
+		return s
+	case reflect.String:
+		return apiextensionsv1.JSONSchemaProps{Type: "string"}
+	case reflect.Bool:
+		return apiextensionsv1.JSONSchemaProps{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return apiextensionsv1.JSONSchemaProps{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return apiextensionsv1.JSONSchemaProps{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return apiextensionsv1.JSONSchemaProps{Type: "string", Format: "byte", Nullable: true}
+		}
+		items := schemaFor(t.Elem())
+		return apiextensionsv1.JSONSchemaProps{
+			Type:     "array",
+			Nullable: true,
+			Items:    &apiextensionsv1.JSONSchemaPropsOrArray{Schema: &items},
+		}
+	case reflect.Map:
+		values := schemaFor(t.Elem())
+		return apiextensionsv1.JSONSchemaProps{
+			Type:                 "object",
+			Nullable:             true,
+			AdditionalProperties: &apiextensionsv1.JSONSchemaPropsOrBool{Allows: true, Schema: &values},
+		}
+	case reflect.Struct:
+		s := apiextensionsv1.JSONSchemaProps{
+			Type:       "object",
+			Properties: map[string]apiextensionsv1.JSONSchemaProps{},
+		}
+		addStructProperties(t, &s)
+		return s
+	default:
+		return apiextensionsv1.JSONSchemaProps{XPreserveUnknownFields: &preserveUnknown}
+	}
+}
+
+func addStructProperties(t reflect.Type, s *apiextensionsv1.JSONSchemaProps) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported field
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// Inlined structs like metav1.TypeMeta
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addStructProperties(f.Type, s)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaFor(f.Type)
+	}
+}
@@ -0,0 +1,110 @@
+package nodeclass
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+type schemaTestInner struct {
+	Value int32 `json:"value"`
+}
+
+type schemaTestObject struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Name       string               `json:"name"`
+	Count      *int64               `json:"count,omitempty"`
+	Enabled    bool                 `json:"enabled"`
+	Ratio      float64              `json:"ratio"`
+	Data       []byte               `json:"data"`
+	Tags       []string             `json:"tags"`
+	Labels     map[string]string    `json:"labels"`
+	Inner      schemaTestInner      `json:"inner"`
+	Created    metav1.Time          `json:"created"`
+	Config     runtime.RawExtension `json:"config"`
+	Untagged   string
+	Ignored    string                     `json:"-"`
+	Arbitrary  interface{}                `json:"arbitrary"`
+	Nested     map[string]schemaTestInner `json:"nested"`
+	unexported string
+}
+
+func TestNewSchema(t *testing.T) {
+	s := NewSchema(schemaTestObject{}, nil)
+	if s.Type != "object" {
+		t.Fatalf("expected type object, got %q", s.Type)
+	}
+
+	tests := []struct {
+		property string
+		typ      string
+		format   string
+		nullable bool
+	}{
+		{property: "kind", typ: "string"},
+		{property: "apiVersion", typ: "string"},
+		{property: "name", typ: "string"},
+		{property: "count", typ: "integer", nullable: true},
+		{property: "enabled", typ: "boolean"},
+		{property: "ratio", typ: "number"},
+		{property: "data", typ: "string", format: "byte", nullable: true},
+		{property: "tags", typ: "array", nullable: true},
+		{property: "labels", typ: "object", nullable: true},
+		{property: "inner", typ: "object"},
+		{property: "created", typ: "string", format: "date-time", nullable: true},
+		{property: "config", typ: "object"},
+		{property: "Untagged", typ: "string"},
+		{property: "arbitrary"},
+		{property: "nested", typ: "object", nullable: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.property, func(t *testing.T) {
+			p, exists := s.Properties[test.property]
+			if !exists {
+				t.Fatalf("expected property %q to exist", test.property)
+			}
+			if p.Type != test.typ {
+				t.Errorf("expected type %q, got %q", test.typ, p.Type)
+			}
+			if p.Format != test.format {
+				t.Errorf("expected format %q, got %q", test.format, p.Format)
+			}
+			if p.Nullable != test.nullable {
+				t.Errorf("expected nullable %t, got %t", test.nullable, p.Nullable)
+			}
+		})
+	}
+
+	if len(s.Properties) != len(tests) {
+		t.Errorf("expected %d properties, got %d", len(tests), len(s.Properties))
+	}
+	if _, exists := s.Properties["TypeMeta"]; exists {
+		t.Errorf("expected TypeMeta to be inlined")
+	}
+	if items := s.Properties["tags"].Items; items == nil || items.Schema == nil || items.Schema.Type != "string" {
+		t.Errorf("expected tags to have string items, got %v", items)
+	}
+	if additional := s.Properties["nested"].AdditionalProperties; additional == nil || additional.Schema == nil || additional.Schema.Properties["value"].Type != "integer" {
+		t.Errorf("expected nested to have the schema of the inner struct as values, got %v", additional)
+	}
+	if p := s.Properties["config"].XPreserveUnknownFields; p == nil || !*p {
+		t.Errorf("expected config to preserve unknown fields")
+	}
+	if p := s.Properties["arbitrary"].XPreserveUnknownFields; p == nil || !*p {
+		t.Errorf("expected arbitrary to preserve unknown fields")
+	}
+}
+
+func TestNewSchemaOverrides(t *testing.T) {
+	s := NewSchema(schemaTestObject{}, map[string]interface{}{"config": schemaTestInner{}})
+	config := s.Properties["config"]
+	if config.XPreserveUnknownFields != nil {
+		t.Errorf("expected the override to replace the raw extension schema")
+	}
+	if config.Properties["value"].Type != "integer" {
+		t.Errorf("expected the override schema, got %v", config)
+	}
+}
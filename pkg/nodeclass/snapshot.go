@@ -0,0 +1,46 @@
+package nodeclass
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+)
+
+// Snapshot is the effective nodeclass of a node, with inheritance & node specific overrides resolved
+type Snapshot struct {
+	NodeClassName  string                       `json:"nodeClassName"`
+	NodeController string                       `json:"nodeController"`
+	Resources      []v1alpha1.NodeClassResource `json:"resources,omitempty"`
+	Config         NodeClassConfig              `json:"config"`
+}
+
+func NewSnapshot(class *v1alpha1.NodeClass, config *NodeClassConfig) *Snapshot {
+	return &Snapshot{
+		NodeClassName:  class.Name,
+		NodeController: class.NodeController,
+		Resources:      class.Resources,
+		Config:         *config,
+	}
+}
+
+// Hash returns the sha256 hash of the content of the snapshot, including the name of the nodeclass
+func (s *Snapshot) Hash() (string, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// NodeClass returns the nodeclass of the snapshot. The raw config is not set, the resolved config is part of the snapshot.
+func (s *Snapshot) NodeClass() *v1alpha1.NodeClass {
+	class := &v1alpha1.NodeClass{
+		NodeController: s.NodeController,
+		Resources:      s.Resources,
+	}
+	class.Name = s.NodeClassName
+	return class
+}
@@ -0,0 +1,35 @@
+package nodeclass
+
+import (
+	"testing"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+)
+
+func TestSnapshotHash(t *testing.T) {
+	config := &NodeClassConfig{Provider: "digitalocean"}
+	a := &v1alpha1.NodeClass{NodeController: "kube-machine"}
+	a.Name = "small"
+	b := &v1alpha1.NodeClass{NodeController: "kube-machine"}
+	b.Name = "large"
+
+	hashA, err := NewSnapshot(a, config).Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := NewSnapshot(b, config).Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA == hashB {
+		t.Errorf("expected snapshots of nodeclasses with different names to have different hashes")
+	}
+
+	again, err := NewSnapshot(a, &NodeClassConfig{Provider: "digitalocean"}).Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != hashA {
+		t.Errorf("expected the hash of equal snapshots to be equal, got %s and %s", hashA, again)
+	}
+}
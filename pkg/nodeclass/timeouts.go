@@ -0,0 +1,45 @@
+package nodeclass
+
+import (
+	"fmt"
+	"time"
+)
+
+// Timeout returns the timeout of the given phase or 0 if none is specified
+func (t *NodeClassPhaseTimeouts) Timeout(phase string) (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+
+	var value string
+	switch phase {
+	case "pending":
+		value = t.Pending
+	case "provisioning":
+		value = t.Provisioning
+	case "launching":
+		value = t.Launching
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q of phase %s: %v", value, phase, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q of phase %s: must be positive", value, phase)
+	}
+	return d, nil
+}
+
+// Validate checks that all timeouts are valid positive durations
+func (t *NodeClassPhaseTimeouts) Validate() error {
+	for _, phase := range []string{"pending", "provisioning", "launching"} {
+		if _, err := t.Timeout(phase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package nodeclass
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodeClassPhaseTimeoutsTimeout(t *testing.T) {
+	timeouts := &NodeClassPhaseTimeouts{
+		Pending:      "10m",
+		Provisioning: "1h30m",
+	}
+
+	tests := []struct {
+		name      string
+		timeouts  *NodeClassPhaseTimeouts
+		phase     string
+		expected  time.Duration
+		expectErr bool
+	}{
+		{
+			name:     "pending",
+			timeouts: timeouts,
+			phase:    "pending",
+			expected: 10 * time.Minute,
+		},
+		{
+			name:     "provisioning",
+			timeouts: timeouts,
+			phase:    "provisioning",
+			expected: 90 * time.Minute,
+		},
+		{
+			name:     "phase without timeout",
+			timeouts: timeouts,
+			phase:    "launching",
+		},
+		{
+			name:     "phase which cannot have a timeout",
+			timeouts: timeouts,
+			phase:    "running",
+		},
+		{
+			name:  "no timeouts",
+			phase: "pending",
+		},
+		{
+			name:      "invalid duration",
+			timeouts:  &NodeClassPhaseTimeouts{Launching: "ten minutes"},
+			phase:     "launching",
+			expectErr: true,
+		},
+		{
+			name:      "negative duration",
+			timeouts:  &NodeClassPhaseTimeouts{Launching: "-5m"},
+			phase:     "launching",
+			expectErr: true,
+		},
+		{
+			name:      "zero duration",
+			timeouts:  &NodeClassPhaseTimeouts{Pending: "0s"},
+			phase:     "pending",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeout, err := test.timeouts.Timeout(test.phase)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if timeout != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, timeout)
+			}
+		})
+	}
+}
+
+func TestNodeClassPhaseTimeoutsValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		timeouts  *NodeClassPhaseTimeouts
+		expectErr bool
+	}{
+		{
+			name: "no timeouts",
+		},
+		{
+			name:     "valid timeouts",
+			timeouts: &NodeClassPhaseTimeouts{Pending: "10m", Provisioning: "30m", Launching: "15m"},
+		},
+		{
+			name:      "one invalid timeout",
+			timeouts:  &NodeClassPhaseTimeouts{Pending: "10m", Launching: "15"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.timeouts.Validate()
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
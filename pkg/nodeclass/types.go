@@ -16,9 +16,20 @@ limitations under the License.
 package nodeclass
 
 type NodeClassConfig struct {
+	// Parent is the name of a nodeclass this config inherits from
+	Parent string `json:"parent,omitempty"`
+	// Fragments are names of nodeclasses which get merged in the given order on top of the parent
+	Fragments []string `json:"fragments,omitempty"`
+
 	DockerMachineFlags map[string]string          `json:"dockerMachineFlags"`
 	Provisioning       NodeClassProvisionerConfig `json:"provisioning"`
 	Provider           string                     `json:"provider"`
+	Hibernation        *NodeClassHibernation      `json:"hibernation,omitempty"`
+	Migration          *NodeClassMigration        `json:"migration,omitempty"`
+	PhaseTimeouts      *NodeClassPhaseTimeouts    `json:"phaseTimeouts,omitempty"`
+	// OverridableKeys lists the fields which can be overridden per node.
+	// Either a top level field like "provisioning" or a single flag like "dockerMachineFlags.digitalocean-size".
+	OverridableKeys []string `json:"overridableKeys,omitempty"`
 }
 
 type NodeClassProvisionerConfig struct {
@@ -39,3 +50,31 @@ type NodeClassProvisioningUser struct {
 	SSHKeys []string `json:"ssh_keys"`
 	Sudo    bool     `json:"sudo"`
 }
+
+// NodeClassHibernation specifies time windows in which the nodes of a class get stopped
+type NodeClassHibernation struct {
+	Schedules []NodeClassHibernationSchedule `json:"schedules"`
+	// TimeZone is the IANA time zone of the schedules. Defaults to UTC.
+	TimeZone string `json:"timeZone"`
+}
+
+// NodeClassHibernationSchedule specifies a hibernation window via cron expressions.
+// The nodes get stopped at Start and get started again at End.
+type NodeClassHibernationSchedule struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// NodeClassMigration configures how the node registered by a kubelet gets matched to the node created by kube-machine
+type NodeClassMigration struct {
+	// MatchBy lists the enabled match methods: name, hostname, address, providerID & systemUUID. Defaults to all methods.
+	MatchBy []string `json:"matchBy,omitempty"`
+}
+
+// NodeClassPhaseTimeouts are the maximum durations a node may stay in a phase before it is considered as stuck, e.g. "15m".
+// Phases without timeout are not checked.
+type NodeClassPhaseTimeouts struct {
+	Pending      string `json:"pending,omitempty"`
+	Provisioning string `json:"provisioning,omitempty"`
+	Launching    string `json:"launching,omitempty"`
+}
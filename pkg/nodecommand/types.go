@@ -0,0 +1,153 @@
+package nodecommand
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	PhaseRunning   = "Running"
+	PhaseCompleted = "Completed"
+	PhaseFailed    = "Failed"
+
+	// DefaultTimeout applies to commands without timeoutSeconds
+	DefaultTimeout = 5 * time.Minute
+)
+
+// NodeCommand executes a command via SSH on all targeted nodes which are managed by kube-machine.
+// The spec must not change once the command ran, the results are only written via the status subresource.
+type NodeCommand struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeCommandSpec   `json:"spec"`
+	Status NodeCommandStatus `json:"status,omitempty"`
+}
+
+type NodeCommandSpec struct {
+	// NodeNames specifies the targeted nodes by name
+	NodeNames []string `json:"nodeNames,omitempty"`
+	// NodeSelector specifies the targeted nodes by labels
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// Command is executed via SSH on every targeted node
+	Command string `json:"command"`
+	// TimeoutSeconds limits the execution per node. Defaults to DefaultTimeout
+	TimeoutSeconds *int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// Timeout returns the maximum execution time per node
+func (s *NodeCommandSpec) Timeout() time.Duration {
+	if s.TimeoutSeconds == nil || *s.TimeoutSeconds <= 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(*s.TimeoutSeconds) * time.Second
+}
+
+type NodeCommandStatus struct {
+	Phase   string              `json:"phase,omitempty"`
+	Results []NodeCommandResult `json:"results,omitempty"`
+}
+
+type NodeCommandResult struct {
+	NodeName       string       `json:"nodeName"`
+	Stdout         string       `json:"stdout,omitempty"`
+	Stderr         string       `json:"stderr,omitempty"`
+	ExitCode       int          `json:"exitCode"`
+	Error          string       `json:"error,omitempty"`
+	StartTime      metav1.Time  `json:"startTime"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+type NodeCommandList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []NodeCommand `json:"items"`
+}
+
+func (in *NodeCommand) DeepCopyInto(out *NodeCommand) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *NodeCommand) DeepCopy() *NodeCommand {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeCommand)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NodeCommand) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+func (in *NodeCommandSpec) DeepCopyInto(out *NodeCommandSpec) {
+	*out = *in
+	if in.NodeNames != nil {
+		out.NodeNames = make([]string, len(in.NodeNames))
+		copy(out.NodeNames, in.NodeNames)
+	}
+	if in.NodeSelector != nil {
+		out.NodeSelector = in.NodeSelector.DeepCopy()
+	}
+	if in.TimeoutSeconds != nil {
+		t := *in.TimeoutSeconds
+		out.TimeoutSeconds = &t
+	}
+}
+
+func (in *NodeCommandStatus) DeepCopyInto(out *NodeCommandStatus) {
+	*out = *in
+	if in.Results != nil {
+		out.Results = make([]NodeCommandResult, len(in.Results))
+		for i := range in.Results {
+			in.Results[i].DeepCopyInto(&out.Results[i])
+		}
+	}
+}
+
+func (in *NodeCommandResult) DeepCopyInto(out *NodeCommandResult) {
+	*out = *in
+	in.StartTime.DeepCopyInto(&out.StartTime)
+	if in.CompletionTime != nil {
+		out.CompletionTime = in.CompletionTime.DeepCopy()
+	}
+}
+
+func (in *NodeCommandList) DeepCopyInto(out *NodeCommandList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		out.Items = make([]NodeCommand, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *NodeCommandList) DeepCopy() *NodeCommandList {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeCommandList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NodeCommandList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
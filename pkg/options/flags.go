@@ -1,6 +1,7 @@
 package options
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -26,15 +27,12 @@ func (n StringMapOptions) StringSlice(key string) []string {
 	return strings.Split(a, ",")
 }
 
-func (n StringMapOptions) Int(key string) int {
-	a := n.options[key]
-	i, _ := strconv.Atoi(a)
-	return i
+func (n StringMapOptions) Int(key string) (int, error) {
+	return strconv.Atoi(n.options[key])
 }
 
-func (n StringMapOptions) Bool(key string) bool {
-	b, _ := strconv.ParseBool(n.options[key])
-	return b
+func (n StringMapOptions) Bool(key string) (bool, error) {
+	return strconv.ParseBool(n.options[key])
 }
 
 func (n StringMapOptions) Names() []string {
@@ -42,5 +40,6 @@ func (n StringMapOptions) Names() []string {
 	for name, _ := range n.options {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
@@ -6,16 +6,57 @@ import (
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/drivers/rpc"
 	"github.com/docker/machine/libmachine/mcnflag"
-	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
-func GetDriverOpts(opts StringMapOptions, mcnflags []mcnflag.Flag, resources []v1alpha1.NodeClassResource) drivers.DriverOptions {
+// kubeMachineFlags are handled by kube-machine itself and are not passed to the driver
+var kubeMachineFlags = map[string]bool{
+	"engine-install-url": true,
+}
+
+// UnknownFlagError is returned for flags which are not supported by the driver
+type UnknownFlagError struct {
+	Name string
+}
+
+func (e *UnknownFlagError) Error() string {
+	return fmt.Sprintf("unknown flag %q", e.Name)
+}
+
+// InvalidFlagValueError is returned for values which cannot be parsed into the type of the flag
+type InvalidFlagValueError struct {
+	Name  string
+	Value string
+	Type  string
+	Err   error
+}
+
+func (e *InvalidFlagValueError) Error() string {
+	return fmt.Sprintf("invalid value %q for %s flag %q: %v", e.Value, e.Type, e.Name, e.Err)
+}
+
+// UnsupportedFlagTypeError is returned for driver flags with a type kube-machine cannot handle
+type UnsupportedFlagTypeError struct {
+	Name string
+	Type string
+}
+
+func (e *UnsupportedFlagTypeError) Error() string {
+	return fmt.Sprintf("unsupported type %s of flag %q", e.Type, e.Name)
+}
+
+// GetDriverOpts returns the driver options for the given flags. Node class resources must already be applied to the flags. See ApplyResources.
+// All invalid flags are returned as an aggregated error of UnknownFlagError, InvalidFlagValueError & UnsupportedFlagTypeError.
+func GetDriverOpts(opts StringMapOptions, mcnflags []mcnflag.Flag) (drivers.DriverOptions, error) {
 	driverOpts := rpcdriver.RPCFlags{
 		Values: make(map[string]interface{}),
 	}
 
+	flags := map[string]mcnflag.Flag{}
 	for _, f := range mcnflags {
 		name := f.String()
+		flags[name] = f
 		driverOpts.Values[name] = f.Default()
 
 		// Hardcoded logic for boolean... :(
@@ -24,27 +65,41 @@ func GetDriverOpts(opts StringMapOptions, mcnflags []mcnflag.Flag, resources []v
 		}
 	}
 
-	names := opts.Names()
-	for _, name := range names {
-		for _, f := range mcnflags {
-			if f.String() != name {
-				continue
+	var errs []error
+	for _, name := range opts.Names() {
+		if _, exists := flags[name]; !exists {
+			if !kubeMachineFlags[name] {
+				errs = append(errs, &UnknownFlagError{Name: name})
 			}
+			continue
+		}
 
-			switch v := driverOpts.Values[name].(type) {
-			case int:
-				driverOpts.Values[name] = opts.Int(name)
-			case string:
-				driverOpts.Values[name] = opts.String(name)
-			case []string:
-				driverOpts.Values[name] = opts.StringSlice(name)
-			case bool:
-				driverOpts.Values[name] = opts.Bool(name)
-			default:
-				panic(fmt.Errorf("unknown flag type %s for %s", v, name))
+		switch v := driverOpts.Values[name].(type) {
+		case int:
+			i, err := opts.Int(name)
+			if err != nil {
+				errs = append(errs, &InvalidFlagValueError{Name: name, Value: opts.String(name), Type: "int", Err: err})
+				continue
 			}
+			driverOpts.Values[name] = i
+		case string:
+			driverOpts.Values[name] = opts.String(name)
+		case []string:
+			driverOpts.Values[name] = opts.StringSlice(name)
+		case bool:
+			b, err := opts.Bool(name)
+			if err != nil {
+				errs = append(errs, &InvalidFlagValueError{Name: name, Value: opts.String(name), Type: "bool", Err: err})
+				continue
+			}
+			driverOpts.Values[name] = b
+		default:
+			errs = append(errs, &UnsupportedFlagTypeError{Name: name, Type: fmt.Sprintf("%T", v)})
 		}
 	}
 
-	return driverOpts
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return driverOpts, nil
 }
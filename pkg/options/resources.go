@@ -0,0 +1,115 @@
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	// ResourceTypeFile materializes the resource as file. The flag with the name of the resource is set to the path of the file.
+	ResourceTypeFile = "file"
+	// ResourceTypeFlag sets the flag with the name of the resource to the content of the resource.
+	ResourceTypeFlag = "flag"
+)
+
+// ResourceGetter returns the content of the Secret or ConfigMap key the reference points to
+type ResourceGetter func(ref *v1.ObjectReference) ([]byte, error)
+
+// ValidateResourceNamespaces returns an error if a resource references a namespace which is not allowed.
+// Otherwise node class authors could read Secrets of any namespace via kube-machine.
+func ValidateResourceNamespaces(resources []v1alpha1.NodeClassResource, allowed []string) error {
+	for _, res := range resources {
+		if res.Reference == nil {
+			continue
+		}
+		if err := ValidateReferenceNamespace(res.Reference, allowed); err != nil {
+			return fmt.Errorf("resource %q: %v", res.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateReferenceNamespace returns an error if the namespace of the reference is not allowed
+func ValidateReferenceNamespace(ref *v1.ObjectReference, allowed []string) error {
+	for _, ns := range allowed {
+		if ref.Namespace == ns {
+			return nil
+		}
+	}
+	return fmt.Errorf("namespace %q is not allowed for resources. Must be one of %v", ref.Namespace, allowed)
+}
+
+// ApplyResources returns the given docker-machine flags merged with the node class resources.
+// Resources take precedence over docker-machine flags with the same name.
+// File resources are written into dir.
+func ApplyResources(flags map[string]string, resources []v1alpha1.NodeClassResource, get ResourceGetter, dir string) (map[string]string, error) {
+	merged := map[string]string{}
+	for k, v := range flags {
+		merged[k] = v
+	}
+
+	for _, res := range resources {
+		if res.Name == "" {
+			return nil, fmt.Errorf("resource without name")
+		}
+		if res.Reference == nil {
+			return nil, fmt.Errorf("resource %q has no reference", res.Name)
+		}
+
+		content, err := get(res.Reference)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get content of resource %q: %v", res.Name, err)
+		}
+
+		switch strings.ToLower(string(res.Type)) {
+		case ResourceTypeFile:
+			path, err := writeResourceFile(dir, res.Name, content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to write resource %q: %v", res.Name, err)
+			}
+			merged[res.Name] = path
+		case ResourceTypeFlag:
+			merged[res.Name] = string(content)
+		default:
+			return nil, fmt.Errorf("unknown type %q of resource %q. Must be one of %s, %s", res.Type, res.Name, ResourceTypeFile, ResourceTypeFlag)
+		}
+	}
+
+	return merged, nil
+}
+
+// WriteResourceFiles writes the file resources into dir again. The paths are the same ApplyResources returned.
+// The driver data only contains the paths, the files must be restored after a restart of kube-machine.
+func WriteResourceFiles(resources []v1alpha1.NodeClassResource, get ResourceGetter, dir string) error {
+	files := []v1alpha1.NodeClassResource{}
+	for _, res := range resources {
+		if strings.ToLower(string(res.Type)) == ResourceTypeFile {
+			files = append(files, res)
+		}
+	}
+	_, err := ApplyResources(nil, files, get, dir)
+	return err
+}
+
+func writeResourceFile(dir, name string, content []byte) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, filepath.Base(name))
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,205 @@
+package options
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	"k8s.io/api/core/v1"
+)
+
+func TestValidateResourceNamespaces(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources []v1alpha1.NodeClassResource
+		allowed   []string
+		expectErr bool
+	}{
+		{
+			name: "allowed namespace",
+			resources: []v1alpha1.NodeClassResource{
+				{Name: "token", Reference: &v1.ObjectReference{Kind: "Secret", Namespace: "kube-system", Name: "do"}},
+			},
+			allowed: []string{"kube-system"},
+		},
+		{
+			name: "not allowed namespace",
+			resources: []v1alpha1.NodeClassResource{
+				{Name: "token", Reference: &v1.ObjectReference{Kind: "Secret", Namespace: "kube-system", Name: "do"}},
+				{Name: "key", Reference: &v1.ObjectReference{Kind: "Secret", Namespace: "team-a", Name: "ssh"}},
+			},
+			allowed:   []string{"kube-system"},
+			expectErr: true,
+		},
+		{
+			name: "empty namespace",
+			resources: []v1alpha1.NodeClassResource{
+				{Name: "token", Reference: &v1.ObjectReference{Kind: "Secret", Name: "do"}},
+			},
+			allowed:   []string{"kube-system"},
+			expectErr: true,
+		},
+		{
+			name: "no allowed namespaces",
+			resources: []v1alpha1.NodeClassResource{
+				{Name: "token", Reference: &v1.ObjectReference{Kind: "Secret", Namespace: "kube-system", Name: "do"}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "resources without reference are left to ApplyResources",
+			resources: []v1alpha1.NodeClassResource{
+				{Name: "token"},
+			},
+			allowed: []string{"kube-system"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateResourceNamespaces(test.resources, test.allowed)
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := map[string]string{
+		"token": "secret-token",
+		"key":   "ssh-key",
+	}
+	get := func(ref *v1.ObjectReference) ([]byte, error) {
+		content, exists := contents[ref.Name]
+		if !exists {
+			return nil, errors.New("not found")
+		}
+		return []byte(content), nil
+	}
+
+	tests := []struct {
+		name      string
+		flags     map[string]string
+		resources []v1alpha1.NodeClassResource
+		expected  map[string]string
+		expectErr bool
+	}{
+		{
+			name:     "flags without resources",
+			flags:    map[string]string{"digitalocean-region": "fra1"},
+			expected: map[string]string{"digitalocean-region": "fra1"},
+		},
+		{
+			name:  "flag resources take precedence over flags",
+			flags: map[string]string{"digitalocean-access-token": "plain", "digitalocean-region": "fra1"},
+			resources: []v1alpha1.NodeClassResource{
+				{Type: ResourceTypeFlag, Name: "digitalocean-access-token", Reference: &v1.ObjectReference{Name: "token"}},
+			},
+			expected: map[string]string{"digitalocean-access-token": "secret-token", "digitalocean-region": "fra1"},
+		},
+		{
+			name: "file resources are set to the path of the file",
+			resources: []v1alpha1.NodeClassResource{
+				{Type: ResourceTypeFile, Name: "digitalocean-ssh-key-path", Reference: &v1.ObjectReference{Name: "key"}},
+			},
+			expected: map[string]string{"digitalocean-ssh-key-path": filepath.Join(dir, "digitalocean-ssh-key-path")},
+		},
+		{
+			name: "unknown type",
+			resources: []v1alpha1.NodeClassResource{
+				{Type: "env", Name: "digitalocean-access-token", Reference: &v1.ObjectReference{Name: "token"}},
+			},
+			expectErr: true,
+		},
+		{
+			name: "missing reference",
+			resources: []v1alpha1.NodeClassResource{
+				{Type: ResourceTypeFlag, Name: "digitalocean-access-token"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "missing content",
+			resources: []v1alpha1.NodeClassResource{
+				{Type: ResourceTypeFlag, Name: "digitalocean-access-token", Reference: &v1.ObjectReference{Name: "unknown"}},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged, err := ApplyResources(test.flags, test.resources, get, dir)
+			if test.expectErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(merged) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, merged)
+			}
+			for k, v := range test.expected {
+				if merged[k] != v {
+					t.Errorf("expected %s=%q, got %q", k, v, merged[k])
+				}
+			}
+		})
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "digitalocean-ssh-key-path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "ssh-key" {
+		t.Errorf("expected the content of the file resource to be written, got %q", content)
+	}
+}
+
+func TestWriteResourceFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	get := func(ref *v1.ObjectReference) ([]byte, error) {
+		if ref.Name != "key" {
+			return nil, errors.New("only file resources must be read")
+		}
+		return []byte("ssh-key"), nil
+	}
+	resources := []v1alpha1.NodeClassResource{
+		{Type: ResourceTypeFlag, Name: "digitalocean-access-token", Reference: &v1.ObjectReference{Name: "token"}},
+		{Type: ResourceTypeFile, Name: "digitalocean-ssh-key-path", Reference: &v1.ObjectReference{Name: "key"}},
+	}
+
+	if err := WriteResourceFiles(resources, get, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "digitalocean-ssh-key-path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "ssh-key" {
+		t.Errorf("expected the content of the file resource to be written, got %q", content)
+	}
+}
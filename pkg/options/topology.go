@@ -0,0 +1,82 @@
+package options
+
+import (
+	"strings"
+)
+
+// Topology describes where a machine is located and which type it has
+type Topology struct {
+	Region       string
+	Zone         string
+	InstanceType string
+}
+
+type topologyFlags struct {
+	region       string
+	zone         string
+	instanceType string
+}
+
+// topologyFlagMapping maps a driver to the docker-machine flags which contain its topology information
+var topologyFlagMapping = map[string]topologyFlags{
+	"amazonec2": {
+		region:       "amazonec2-region",
+		zone:         "amazonec2-zone",
+		instanceType: "amazonec2-instance-type",
+	},
+	"azure": {
+		region:       "azure-location",
+		instanceType: "azure-size",
+	},
+	"digitalocean": {
+		region:       "digitalocean-region",
+		instanceType: "digitalocean-size",
+	},
+	"exoscale": {
+		zone:         "exoscale-availability-zone",
+		instanceType: "exoscale-instance-profile",
+	},
+	"google": {
+		zone:         "google-zone",
+		instanceType: "google-machine-type",
+	},
+	"hetzner": {
+		region:       "hetzner-server-location",
+		instanceType: "hetzner-server-type",
+	},
+	"openstack": {
+		region:       "openstack-region",
+		zone:         "openstack-availability-zone",
+		instanceType: "openstack-flavor-name",
+	},
+}
+
+// GetTopology returns the topology of a machine created by the given driver with the given docker-machine flags.
+// Unknown drivers & unset flags result in empty fields.
+func GetTopology(driver string, flags map[string]string) Topology {
+	m, exists := topologyFlagMapping[driver]
+	if !exists {
+		return Topology{}
+	}
+
+	t := Topology{
+		Region:       flags[m.region],
+		Zone:         flags[m.zone],
+		InstanceType: flags[m.instanceType],
+	}
+
+	switch driver {
+	case "amazonec2":
+		// The zone flag only contains the zone letter
+		if t.Zone != "" && t.Region != "" {
+			t.Zone = t.Region + t.Zone
+		}
+	case "google":
+		// Zones are named <region>-<zone>. E.g. europe-west1-b
+		if i := strings.LastIndex(t.Zone, "-"); i > 0 {
+			t.Region = t.Zone[:i]
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,197 @@
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	groupLabelKey = "kubemachine.k8s.io/shard-group"
+)
+
+// Membership tracks the replicas of a controller via Leases and assigns keys to replicas via rendezvous hashing.
+// Every replica renews its own Lease. Replicas whose Lease expired are no members anymore and their keys get reassigned.
+type Membership struct {
+	client        kubernetes.Interface
+	namespace     string
+	group         string
+	identity      string
+	leaseDuration time.Duration
+
+	lock     sync.RWMutex
+	members  []string
+	handlers []func()
+}
+
+func New(client kubernetes.Interface, namespace, group, identity string, leaseDuration time.Duration) *Membership {
+	return &Membership{
+		client:        client,
+		namespace:     namespace,
+		group:         group,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// AddChangeHandler registers a function which gets called after the members changed
+func (m *Membership) AddChangeHandler(f func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers = append(m.handlers, f)
+}
+
+// Run renews the Lease of this replica and refreshes the members until stopCh gets closed.
+// On stop the Lease gets deleted, so the other replicas take over immediately.
+func (m *Membership) Run(stopCh <-chan struct{}) {
+	wait.Until(m.sync, m.leaseDuration/3, stopCh)
+
+	err := m.client.CoordinationV1().Leases(m.namespace).Delete(m.leaseName(), &metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		glog.V(0).Infof("Failed to delete shard lease %s/%s: %v", m.namespace, m.leaseName(), err)
+	}
+}
+
+// Owns returns true if the given key is assigned to this replica
+func (m *Membership) Owns(key string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var owner string
+	var max uint64
+	for _, member := range m.members {
+		if sum := score(member, key); owner == "" || sum > max {
+			owner, max = member, sum
+		}
+	}
+	return owner == m.identity
+}
+
+// IsMember returns true if this replica holds a valid Lease
+func (m *Membership) IsMember() bool {
+	return m.HasMember(m.identity)
+}
+
+// HasMember returns true if the replica with the given identity holds a valid Lease
+func (m *Membership) HasMember(identity string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _, member := range m.members {
+		if member == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// Identity returns the identity of this replica
+func (m *Membership) Identity() string {
+	return m.identity
+}
+
+func (m *Membership) sync() {
+	if err := m.renew(); err != nil {
+		glog.V(0).Infof("Failed to renew shard lease %s/%s: %v", m.namespace, m.leaseName(), err)
+	}
+
+	members, err := m.listMembers()
+	if err != nil {
+		glog.V(0).Infof("Failed to list shard members: %v", err)
+		return
+	}
+
+	m.lock.Lock()
+	changed := !reflect.DeepEqual(members, m.members)
+	m.members = members
+	handlers := m.handlers
+	m.lock.Unlock()
+
+	if changed {
+		glog.V(2).Infof("Shard members changed: %v", members)
+		for _, h := range handlers {
+			h()
+		}
+	}
+}
+
+func (m *Membership) renew() error {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(m.leaseDuration.Seconds())
+
+	leases := m.client.CoordinationV1().Leases(m.namespace)
+	lease, err := leases.Get(m.leaseName(), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = leases.Create(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.leaseName(),
+				Namespace: m.namespace,
+				Labels:    map[string]string{groupLabelKey: m.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = &m.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(lease)
+	return err
+}
+
+// listMembers returns the sorted identities of all replicas with a valid Lease
+func (m *Membership) listMembers() ([]string, error) {
+	list, err := m.client.CoordinationV1().Leases(m.namespace).List(metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{groupLabelKey: m.group}).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := []string{}
+	for _, lease := range list.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expires := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if time.Now().After(expires) {
+			continue
+		}
+		members = append(members, *lease.Spec.HolderIdentity)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// score returns the rendezvous hash weight of the key for the member. The bits of fast non-cryptographic hashes like FNV
+// are not evenly distributed for similar inputs, which leaves some members without keys.
+func score(member, key string) uint64 {
+	sum := sha256.Sum256([]byte(member + "/" + key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func (m *Membership) leaseName() string {
+	return fmt.Sprintf("%s-%s", m.group, m.identity)
+}
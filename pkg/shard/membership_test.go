@@ -0,0 +1,137 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestMembership(identity string, members ...string) *Membership {
+	return &Membership{identity: identity, members: members}
+}
+
+// owners returns the member which owns the given key from the view of every member
+func owners(members []string, key string) []string {
+	var result []string
+	for _, member := range members {
+		if newTestMembership(member, members...).Owns(key) {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func TestOwns(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{
+			name:    "single member",
+			members: []string{"a"},
+		},
+		{
+			name:    "three members",
+			members: []string{"a", "b", "c"},
+		},
+		{
+			name:    "ten members",
+			members: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assigned := map[string]int{}
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("node-%d", i)
+				o := owners(test.members, key)
+				if len(o) != 1 {
+					t.Fatalf("expected key %s to be owned by exactly one member, got %v", key, o)
+				}
+				assigned[o[0]]++
+			}
+			for _, member := range test.members {
+				if assigned[member] == 0 {
+					t.Errorf("expected member %s to own keys, got none of %v", member, assigned)
+				}
+			}
+		})
+	}
+}
+
+func TestOwnsWithoutMembers(t *testing.T) {
+	m := newTestMembership("a")
+	if m.Owns("node-1") {
+		t.Errorf("expected a replica without members to own nothing")
+	}
+}
+
+func TestOwnsRebalancing(t *testing.T) {
+	before := []string{"a", "b", "c", "d"}
+	after := []string{"a", "b", "d"}
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("node-%d", i)
+		oldOwner := owners(before, key)[0]
+		newOwner := owners(after, key)[0]
+		// Only the keys of the removed member get reassigned
+		if oldOwner != "c" && oldOwner != newOwner {
+			t.Errorf("expected key %s to stay with %s, got reassigned to %s", key, oldOwner, newOwner)
+		}
+	}
+}
+
+func TestHasMember(t *testing.T) {
+	m := newTestMembership("a", "b", "c")
+	if m.IsMember() {
+		t.Errorf("expected a to be no member")
+	}
+	if !m.HasMember("b") {
+		t.Errorf("expected b to be a member")
+	}
+	if m.HasMember("d") {
+		t.Errorf("expected d to be no member")
+	}
+}
+
+func TestListMembers(t *testing.T) {
+	now := time.Now()
+	lease := func(name, group, holder string, renewed time.Time) *coordinationv1.Lease {
+		seconds := int32(15)
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "kube-system",
+				Labels:    map[string]string{groupLabelKey: group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				RenewTime:            &renewTime,
+			},
+		}
+	}
+
+	client := fake.NewSimpleClientset(
+		lease("kube-machine-c", "kube-machine", "c", now),
+		lease("kube-machine-a", "kube-machine", "a", now.Add(-5*time.Second)),
+		lease("kube-machine-b", "kube-machine", "b", now.Add(-time.Minute)),
+		lease("other-d", "other", "d", now),
+	)
+	m := New(client, "kube-system", "kube-machine", "a", 15*time.Second)
+
+	members, err := m.listMembers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "c"}
+	if fmt.Sprint(members) != fmt.Sprint(expected) {
+		t.Errorf("expected members %v, got %v", expected, members)
+	}
+}
@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/golang/glog"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Server is a validating admission webhook for NodeClasses, Nodes & NodeCommands
+type Server struct {
+	controllerName     string
+	controllerUsers    map[string]bool
+	resourceNamespaces []string
+
+	createFlagsLock *sync.Mutex
+	createFlags     map[string][]mcnflag.Flag
+}
+
+// New returns a webhook server. Only the given controller users are allowed to change the annotations owned by kube-machine.
+// Node class resources may only reference the given namespaces.
+func New(controllerName string, controllerUsers []string, resourceNamespaces []string) *Server {
+	users := map[string]bool{}
+	for _, u := range controllerUsers {
+		users[u] = true
+	}
+	return &Server{
+		controllerName:     controllerName,
+		controllerUsers:    users,
+		resourceNamespaces: resourceNamespaces,
+		createFlagsLock:    &sync.Mutex{},
+		createFlags:        map[string][]mcnflag.Flag{},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate/nodeclasses", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, s.validateNodeClass)
+	})
+	mux.HandleFunc("/validate/nodes", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, s.validateNode)
+	})
+	mux.HandleFunc("/validate/nodecommands", func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, s.validateNodeCommand)
+	})
+	return mux
+}
+
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	glog.Infof("Starting admission webhook, listening on %s", addr)
+	return http.ListenAndServeTLS(addr, certFile, keyFile, s.Handler())
+}
+
+func serve(w http.ResponseWriter, r *http.Request, validate func(*admissionv1.AdmissionRequest) error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	if err := validate(review.Request); err != nil {
+		glog.V(4).Infof("Denied %s of %s %q: %v", review.Request.Operation, review.Request.Kind.Kind, review.Request.Name, err)
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	review.Request = nil
+	review.Response = response
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
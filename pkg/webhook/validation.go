@@ -0,0 +1,227 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/kube-node/kube-machine/pkg/controller/node"
+	"github.com/kube-node/kube-machine/pkg/libmachine"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
+	"github.com/kube-node/kube-machine/pkg/options"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+const (
+	validationMachineName = "kube-machine-webhook-validation"
+)
+
+var ownerRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_-]*(:[a-z_][a-z0-9_-]*)?$`)
+
+// validateNodeClass rejects nodeclasses of this controller with a not installed provider,
+// invalid docker-machine flags, resources of not allowed namespaces, invalid hibernation schedules, unknown migration match methods, invalid phase timeouts or files which would break the provisioning command.
+func (s *Server) validateNodeClass(req *admissionv1.AdmissionRequest) error {
+	if req.Operation == admissionv1.Delete {
+		return nil
+	}
+
+	class := &v1alpha1.NodeClass{}
+	if err := json.Unmarshal(req.Object.Raw, class); err != nil {
+		return fmt.Errorf("failed to decode nodeclass: %v", err)
+	}
+	if class.NodeController != s.controllerName {
+		return nil
+	}
+
+	config := &nodeclass.NodeClassConfig{}
+	if err := json.Unmarshal(class.Config.Raw, config); err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+
+	var errs []error
+	if config.Provider == "" && config.Parent == "" {
+		errs = append(errs, errors.New("no provider specified"))
+	}
+	// Flags of a class without provider can only be validated together with its parent
+	if config.Provider != "" {
+		if err := validateDriverInstalled(config.Provider); err != nil {
+			errs = append(errs, err)
+		} else if mcnFlags, err := s.getCreateFlags(config.Provider); err != nil {
+			errs = append(errs, err)
+		} else if err := validateFlags(mcnFlags, config.DockerMachineFlags, class.Resources); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := options.ValidateResourceNamespaces(class.Resources, s.resourceNamespaces); err != nil {
+		errs = append(errs, err)
+	}
+	if config.Hibernation != nil {
+		if _, err := config.Hibernation.InWindow(time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("invalid hibernation: %v", err))
+		}
+	}
+	if err := config.Migration.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid migration: %v", err))
+	}
+	if err := config.PhaseTimeouts.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid phase timeouts: %v", err))
+	}
+	for _, f := range config.Provisioning.Files {
+		if err := validateFile(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateDriverInstalled checks if the driver plugin binary exists.
+// Core drivers are part of the docker-machine binary.
+func validateDriverInstalled(provider string) error {
+	binary := fmt.Sprintf("docker-machine-driver-%s", provider)
+	for _, d := range localbinary.CoreDrivers {
+		if d == provider {
+			binary = "docker-machine"
+		}
+	}
+
+	if _, err := exec.LookPath(binary); err != nil {
+		return fmt.Errorf("driver for provider %q is not installed: %v", provider, err)
+	}
+	return nil
+}
+
+// getCreateFlags returns the create flags of the driver of the provider.
+// They are cached per provider, so the driver plugin gets only started once instead of on every admission request.
+func (s *Server) getCreateFlags(provider string) ([]mcnflag.Flag, error) {
+	s.createFlagsLock.Lock()
+	defer s.createFlagsLock.Unlock()
+	if mcnFlags, exists := s.createFlags[provider]; exists {
+		return mcnFlags, nil
+	}
+
+	rawDriver, err := json.Marshal(&drivers.BaseDriver{MachineName: validationMachineName})
+	if err != nil {
+		return nil, err
+	}
+
+	mapi := libmachine.New()
+	defer mapi.Close()
+
+	h, err := mapi.NewHost(provider, rawDriver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load driver for provider %q: %v", provider, err)
+	}
+	mcnFlags := h.Driver.GetCreateFlags()
+	s.createFlags[provider] = mcnFlags
+	return mcnFlags, nil
+}
+
+// validateFlags checks the docker-machine flags against the create flags of the driver.
+// The values of resources are not known at this point, only their names get validated.
+func validateFlags(mcnFlags []mcnflag.Flag, flags map[string]string, resources []v1alpha1.NodeClassResource) error {
+	// Resources take precedence over flags with the same name
+	remaining := map[string]string{}
+	for k, v := range flags {
+		remaining[k] = v
+	}
+	var errs []error
+	for _, res := range resources {
+		delete(remaining, res.Name)
+
+		known := false
+		for _, f := range mcnFlags {
+			if f.String() == res.Name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("resource %q: %v", res.Name, &options.UnknownFlagError{Name: res.Name}))
+		}
+	}
+
+	if _, err := options.GetDriverOpts(options.New(remaining), mcnFlags); err != nil {
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// validateFile checks the values which get rendered into the provisioning command template
+func validateFile(f nodeclass.NodeClassProvisioningConfigFile) error {
+	if !path.IsAbs(f.Path) || strings.ContainsAny(f.Path, "\"'`$\\ \n") {
+		return fmt.Errorf("file %q: path must be absolute and must not contain quotes, spaces or shell characters", f.Path)
+	}
+	if _, err := strconv.ParseUint(f.Permissions, 8, 32); err != nil {
+		return fmt.Errorf("file %q: permissions %q must be octal", f.Path, f.Permissions)
+	}
+	if !ownerRegexp.MatchString(f.Owner) {
+		return fmt.Errorf("file %q: invalid owner %q", f.Path, f.Owner)
+	}
+	return nil
+}
+
+// validateNode rejects changes of annotations owned by kube-machine by anyone but kube-machine itself
+func (s *Server) validateNode(req *admissionv1.AdmissionRequest) error {
+	if req.Operation == admissionv1.Delete || s.controllerUsers[req.UserInfo.Username] {
+		return nil
+	}
+
+	newNode := &corev1.Node{}
+	if err := json.Unmarshal(req.Object.Raw, newNode); err != nil {
+		return fmt.Errorf("failed to decode node: %v", err)
+	}
+	oldNode := &corev1.Node{}
+	if req.Operation == admissionv1.Update {
+		if err := json.Unmarshal(req.OldObject.Raw, oldNode); err != nil {
+			return fmt.Errorf("failed to decode old node: %v", err)
+		}
+	}
+
+	var changed []string
+	for _, key := range node.OwnedAnnotationKeys {
+		if oldNode.Annotations[key] != newNode.Annotations[key] {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("annotations %v are owned by kube-machine and must not be changed by %s", changed, req.UserInfo.Username)
+	}
+	return nil
+}
+
+// validateNodeCommand rejects changes of the spec of a nodecommand which already ran, so its results stay auditable
+func (s *Server) validateNodeCommand(req *admissionv1.AdmissionRequest) error {
+	if req.Operation != admissionv1.Update {
+		return nil
+	}
+
+	newCmd := &nodecommand.NodeCommand{}
+	if err := json.Unmarshal(req.Object.Raw, newCmd); err != nil {
+		return fmt.Errorf("failed to decode nodecommand: %v", err)
+	}
+	oldCmd := &nodecommand.NodeCommand{}
+	if err := json.Unmarshal(req.OldObject.Raw, oldCmd); err != nil {
+		return fmt.Errorf("failed to decode old nodecommand: %v", err)
+	}
+
+	if oldCmd.Status.Phase != "" && !equality.Semantic.DeepEqual(oldCmd.Spec, newCmd.Spec) {
+		return fmt.Errorf("spec of nodecommand %s must not be changed after it ran", oldCmd.Name)
+	}
+	return nil
+}
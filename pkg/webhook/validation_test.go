@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/kube-node/kube-machine/pkg/nodeclass"
+	"github.com/kube-node/kube-machine/pkg/nodecommand"
+	"github.com/kube-node/nodeset/pkg/nodeset/v1alpha1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidateFlags(t *testing.T) {
+	mcnFlags := []mcnflag.Flag{
+		mcnflag.StringFlag{Name: "digitalocean-access-token"},
+		mcnflag.StringFlag{Name: "digitalocean-region", Value: "nyc3"},
+		mcnflag.IntFlag{Name: "digitalocean-size-gb", Value: 20},
+		mcnflag.BoolFlag{Name: "digitalocean-ipv6"},
+	}
+
+	tests := []struct {
+		name      string
+		flags     map[string]string
+		resources []v1alpha1.NodeClassResource
+		expectErr bool
+	}{
+		{
+			name:  "known flags",
+			flags: map[string]string{"digitalocean-region": "fra1", "digitalocean-size-gb": "40", "digitalocean-ipv6": "true"},
+		},
+		{
+			name:  "flags handled by kube-machine",
+			flags: map[string]string{"engine-install-url": "https://get.docker.com"},
+		},
+		{
+			name:      "unknown flag",
+			flags:     map[string]string{"digitalocean-zone": "fra1"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid int value",
+			flags:     map[string]string{"digitalocean-size-gb": "large"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid bool value",
+			flags:     map[string]string{"digitalocean-ipv6": "maybe"},
+			expectErr: true,
+		},
+		{
+			name:      "known resource",
+			resources: []v1alpha1.NodeClassResource{{Name: "digitalocean-access-token"}},
+		},
+		{
+			name:      "unknown resource",
+			resources: []v1alpha1.NodeClassResource{{Name: "digitalocean-token"}},
+			expectErr: true,
+		},
+		{
+			name:      "resources take precedence over flags",
+			flags:     map[string]string{"digitalocean-size-gb": "large"},
+			resources: []v1alpha1.NodeClassResource{{Name: "digitalocean-size-gb"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateFlags(mcnFlags, test.flags, test.resources)
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      nodeclass.NodeClassProvisioningConfigFile
+		expectErr bool
+	}{
+		{
+			name: "valid file",
+			file: nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/kubernetes/kubelet.conf", Permissions: "0600", Owner: "root"},
+		},
+		{
+			name: "owner with group",
+			file: nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/kubernetes/kubelet.conf", Permissions: "644", Owner: "root:docker"},
+		},
+		{
+			name:      "relative path",
+			file:      nodeclass.NodeClassProvisioningConfigFile{Path: "etc/kubernetes/kubelet.conf", Permissions: "0600", Owner: "root"},
+			expectErr: true,
+		},
+		{
+			name:      "path with shell characters",
+			file:      nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/$(reboot)", Permissions: "0600", Owner: "root"},
+			expectErr: true,
+		},
+		{
+			name:      "path with quotes",
+			file:      nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/a'b", Permissions: "0600", Owner: "root"},
+			expectErr: true,
+		},
+		{
+			name:      "non octal permissions",
+			file:      nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/kubernetes/kubelet.conf", Permissions: "0800", Owner: "root"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid owner",
+			file:      nodeclass.NodeClassProvisioningConfigFile{Path: "/etc/kubernetes/kubelet.conf", Permissions: "0600", Owner: "root; reboot"},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateFile(test.file)
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateNodeClass(t *testing.T) {
+	s := New("kube-machine", nil, []string{"kube-system"})
+
+	tests := []struct {
+		name      string
+		class     string
+		config    string
+		operation admissionv1.Operation
+		expectErr bool
+	}{
+		{
+			name:   "class of another controller",
+			class:  "other",
+			config: `{}`,
+		},
+		{
+			name:      "deletion",
+			config:    `{}`,
+			operation: admissionv1.Delete,
+		},
+		{
+			name:   "class with parent",
+			config: `{"parent":"base"}`,
+		},
+		{
+			name:      "no provider",
+			config:    `{}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid config",
+			config:    `{"provisioning":"yes"}`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid file",
+			config:    `{"parent":"base","provisioning":{"files":[{"path":"relative","permissions":"0600","owner":"root"}]}}`,
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller := "kube-machine"
+			if test.class != "" {
+				controller = test.class
+			}
+			operation := test.operation
+			if operation == "" {
+				operation = admissionv1.Create
+			}
+			class := &v1alpha1.NodeClass{
+				ObjectMeta:     metav1.ObjectMeta{Name: "test"},
+				NodeController: controller,
+				Config:         runtime.RawExtension{Raw: []byte(test.config)},
+			}
+			err := s.validateNodeClass(&admissionv1.AdmissionRequest{
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: mustMarshal(t, class)},
+			})
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateNode(t *testing.T) {
+	s := New("kube-machine", []string{"system:serviceaccount:kube-system:kube-machine"}, nil)
+
+	tests := []struct {
+		name           string
+		user           string
+		operation      admissionv1.Operation
+		oldAnnotations map[string]string
+		newAnnotations map[string]string
+		expectErr      bool
+	}{
+		{
+			name:           "other annotation changed",
+			user:           "admin",
+			operation:      admissionv1.Update,
+			oldAnnotations: map[string]string{"node.k8s.io/state": "running"},
+			newAnnotations: map[string]string{"node.k8s.io/state": "running", "example.com/team": "a"},
+		},
+		{
+			name:           "owned annotation changed by controller",
+			user:           "system:serviceaccount:kube-system:kube-machine",
+			operation:      admissionv1.Update,
+			oldAnnotations: map[string]string{"node.k8s.io/state": "pending"},
+			newAnnotations: map[string]string{"node.k8s.io/state": "running"},
+		},
+		{
+			name:           "owned annotation changed by user",
+			user:           "admin",
+			operation:      admissionv1.Update,
+			oldAnnotations: map[string]string{"node.k8s.io/state": "pending"},
+			newAnnotations: map[string]string{"node.k8s.io/state": "running"},
+			expectErr:      true,
+		},
+		{
+			name:           "owned annotation removed by user",
+			user:           "admin",
+			operation:      admissionv1.Update,
+			oldAnnotations: map[string]string{"node.k8s.io/state": "running"},
+			expectErr:      true,
+		},
+		{
+			name:           "node created by user with owned annotation",
+			user:           "admin",
+			operation:      admissionv1.Create,
+			newAnnotations: map[string]string{"node.k8s.io/state": "running"},
+			expectErr:      true,
+		},
+		{
+			name:      "node deleted by user",
+			user:      "admin",
+			operation: admissionv1.Delete,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &admissionv1.AdmissionRequest{
+				Operation: test.operation,
+				UserInfo:  authenticationv1.UserInfo{Username: test.user},
+				Object:    runtime.RawExtension{Raw: mustMarshal(t, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: test.newAnnotations}})},
+				OldObject: runtime.RawExtension{Raw: mustMarshal(t, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Annotations: test.oldAnnotations}})},
+			}
+			err := s.validateNode(req)
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateNodeCommand(t *testing.T) {
+	s := New("kube-machine", nil, nil)
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		oldCmd    nodecommand.NodeCommand
+		newCmd    nodecommand.NodeCommand
+		expectErr bool
+	}{
+		{
+			name:      "creation",
+			operation: admissionv1.Create,
+			newCmd:    nodecommand.NodeCommand{Spec: nodecommand.NodeCommandSpec{Command: "uptime"}},
+		},
+		{
+			name:      "spec changed before it ran",
+			operation: admissionv1.Update,
+			oldCmd:    nodecommand.NodeCommand{Spec: nodecommand.NodeCommandSpec{Command: "uptime"}},
+			newCmd:    nodecommand.NodeCommand{Spec: nodecommand.NodeCommandSpec{Command: "df -h"}},
+		},
+		{
+			name:      "status changed after it ran",
+			operation: admissionv1.Update,
+			oldCmd: nodecommand.NodeCommand{
+				Spec:   nodecommand.NodeCommandSpec{Command: "uptime"},
+				Status: nodecommand.NodeCommandStatus{Phase: nodecommand.PhaseRunning},
+			},
+			newCmd: nodecommand.NodeCommand{
+				Spec:   nodecommand.NodeCommandSpec{Command: "uptime"},
+				Status: nodecommand.NodeCommandStatus{Phase: nodecommand.PhaseCompleted},
+			},
+		},
+		{
+			name:      "spec changed after it ran",
+			operation: admissionv1.Update,
+			oldCmd: nodecommand.NodeCommand{
+				Spec:   nodecommand.NodeCommandSpec{Command: "uptime"},
+				Status: nodecommand.NodeCommandStatus{Phase: nodecommand.PhaseCompleted},
+			},
+			newCmd: nodecommand.NodeCommand{
+				Spec:   nodecommand.NodeCommandSpec{Command: "rm -rf /"},
+				Status: nodecommand.NodeCommandStatus{Phase: nodecommand.PhaseCompleted},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &admissionv1.AdmissionRequest{
+				Operation: test.operation,
+				Object:    runtime.RawExtension{Raw: mustMarshal(t, &test.newCmd)},
+				OldObject: runtime.RawExtension{Raw: mustMarshal(t, &test.oldCmd)},
+			}
+			err := s.validateNodeCommand(req)
+			if test.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !test.expectErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, obj interface{}) []byte {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}